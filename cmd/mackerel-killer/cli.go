@@ -1,12 +1,19 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -20,6 +27,10 @@ const (
 	ExitCodeError
 	ExitCodeParseFlagError
 	ExitCodeInvalidFlagError
+	ExitCodeLimitExceeded
+	ExitCodePlanMismatch
+	ExitCodeInterrupted
+	ExitCodePartialSuccess
 )
 
 const Name = "mkk"
@@ -34,6 +45,24 @@ var (
 	quiet   bool
 	debug   bool
 	version bool
+
+	listFilters bool
+	maxAttempts int
+	bulkSize    int
+
+	maxRetire        int
+	maxRetirePercent float64
+
+	continueOnError bool
+	allowWorking    bool
+
+	protectFile string
+	protection  *mkk.Protection
+
+	metadataNamespace string
+
+	nowTime string
+	clock   mkk.Clock
 )
 
 type cli struct {
@@ -42,6 +71,24 @@ type cli struct {
 }
 
 func (c *cli) run(args []string) int {
+	ctx, stop := c.withSignals(context.Background())
+	defer stop()
+
+	if len(args) > 1 {
+		switch args[1] {
+		case "plan":
+			return c.runPlan(ctx, args[1:])
+		case "apply":
+			return c.runApply(ctx, args[1:])
+		case "run":
+			return c.runConfig(ctx, args[1:])
+		case "explain":
+			return c.runExplain(ctx, args[1:])
+		case "snapshot":
+			return c.runSnapshot(ctx, args[1:])
+		}
+	}
+
 	if err := c.parseFlags(args); err != nil {
 		c.printErrorf("Error occurred while parsing flags: %s", err)
 		return ExitCodeParseFlagError
@@ -54,106 +101,251 @@ func (c *cli) run(args []string) int {
 		return ExitCodeOK
 	}
 
+	if listFilters {
+		for _, name := range mkk.RegisteredFilters() {
+			fmt.Fprintln(c.outStream, name)
+		}
+		return ExitCodeOK
+	}
+
 	if err := validateFlags(); err != nil {
 		c.printErrorf("Flag validation fails: %s", err)
 		return ExitCodeInvalidFlagError
 	}
 
-	c.printDebugf("Raw hosts flag: %v", hosts)
+	j, code := c.newJobFromFlags()
+	if code != ExitCodeOK {
+		return code
+	}
 
-	param, err := parseHosts(hosts)
+	client, err := c.newClient(ctx)
 	if err != nil {
-		c.printErrorf("Error occurred while parsing hosts query parameters: %s\n", err)
-		return ExitCodeInvalidFlagError
+		c.printErrorf("Error occurred while reading a snapshot: %s", err)
+		return ExitCodeError
 	}
+	defer c.printRetries(client)
 
-	c.printDebugf("Parsed mackerel.FindHostsParam: %v", param)
-	c.printDebugf("Raw filters flag: %v", filters)
+	// Hosts are never retired against a snapshot
+	j.dryRun = j.dryRun || len(fromSnapshot) > 0
 
-	fs, err := parseFilters(filters)
-	if err != nil {
-		c.printErrorf("Error occurred while parsing filters: %s\n", err)
-		return ExitCodeInvalidFlagError
-	}
+	w := c.newRecordWriter()
+	defer c.flushRecords(w)
 
-	if c.debug {
-		for i, f := range fs {
-			c.printDebugf("Parsed filter #%d: %v", i, f)
+	return c.runJob(ctx, client, j, w)
+}
+
+// withSignals returns a context which is cancelled on SIGINT or SIGTERM
+// The signals are handled only once, so a second one terminates the process as usual
+func (c *cli) withSignals(parent context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case sig := <-sigCh:
+			// Restore the default behavior so that another signal terminates mkk immediately
+			signal.Stop(sigCh)
+			c.printInfof("Received %v, stopping after the in-flight host... (send it again to quit immediately)", sig)
+			cancel()
+		case <-ctx.Done():
 		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(sigCh)
+		cancel()
 	}
+}
 
-	client := mkk.NewMkk(token)
+// retire retires the given hosts in batches with the bulk-retire endpoint
+// The hosts in a failed batch are retired one by one, and it stops after that batch unless the job continues on error
+// When the context is cancelled, it stops after the in-flight request and prints the retired hosts
+func (c *cli) retire(ctx context.Context, client *mkk.Mkk, j *job, hs []*mackerel.Host, w recordWriter) int {
+	c.printInfof("Retiring hosts...")
 
-	c.printInfof("Finding hosts...")
-	hs, err := client.FindHosts(param, fs)
-	if err != nil {
-		c.printErrorf("Error occurred while finding hosts: %s\n", err)
-		return ExitCodeError
+	var retired []*mackerel.Host
+	var records []*hostRecord
+	var failed int
+
+	// Each chunk is a single batch of KillAllContext, which falls back to the default size in the same way
+	size := client.BulkRetireSize
+	if size <= 0 {
+		size = mkk.DefaultBulkRetireSize
 	}
 
-	if len(hs) > 0 {
-		c.printInfof("%d hosts found", len(hs))
+	for start := 0; start < len(hs); start += size {
+		end := start + size
+		if end > len(hs) {
+			end = len(hs)
+		}
+
+		results, ctxErr := client.KillAllContext(ctx, hs[start:end])
+
+		for _, res := range results {
+			h := res.Host
 
-		if c.debug {
-			for i, h := range hs {
-				c.printDebugf("Found host #%d: %v", i, h)
+			if mkk.IsRefused(res.Err) {
+				c.printInfof("Skipped host: id: %v, name: %v: %s", h.ID, h.Name, res.Err)
+
+				r := newHostRecord(j.name, h, ResultSkipped, res.Err)
+				w.Write(r)
+				records = append(records, r)
+				continue
 			}
+
+			if res.Err != nil {
+				c.printErrorf("Error occurred while retiring a host: id: %v, name: %v: %s", h.ID, h.Name, res.Err)
+
+				r := newHostRecord(j.name, h, ResultFailed, res.Err)
+				w.Write(r)
+				records = append(records, r)
+				failed++
+				continue
+			}
+
+			r := newHostRecord(j.name, h, ResultRetired, nil)
+			w.Write(r)
+			records = append(records, r)
+			retired = append(retired, h)
 		}
-	} else {
-		c.printInfof("No hosts found with the specified query parameters and filters")
-		return ExitCodeOK
-	}
 
-	if dryRun {
-		c.printInfof("Running in Dry Run mode")
-		c.printInfof("Hosts below will be retired without --dry-run flag\n")
+		if ctxErr != nil {
+			c.printInterrupted(retired, len(hs))
+			return ExitCodeInterrupted
+		}
 
-		for i, h := range hs {
-			c.printInfof("#%d id: %v, name: %v", i, h.ID, h.Name)
+		if failed > 0 && !j.continueOnError {
+			break
 		}
+	}
+
+	if j.continueOnError {
+		c.printSummary(records)
+	}
 
+	switch {
+	case failed == 0:
 		return ExitCodeOK
+	case len(retired) == 0:
+		return ExitCodeError
+	default:
+		return ExitCodePartialSuccess
 	}
+}
 
-	c.printInfof("Retiring hosts...")
-	for i, h := range hs {
-		if err := client.Kill(h); err != nil {
-			c.printErrorf("Error occurred while retiring a host: id: %v, name: %v: %s", h.ID, h.Name, err)
-			return ExitCodeError
-		}
+// printSummary prints a table of the retired, failed and skipped hosts
+func (c *cli) printSummary(records []*hostRecord) {
+	count := make(map[string]int)
+	for _, r := range records {
+		count[r.Result]++
+	}
 
-		c.printInfof("#%v Retired: id: %v, name: %v", i, h.ID, h.Name)
+	c.printInfof("Retired %d hosts, failed to retire %d hosts, skipped %d hosts", count[ResultRetired], count[ResultFailed], count[ResultSkipped])
+
+	tw := tabwriter.NewWriter(c.errStream, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "RESULT\tID\tNAME\tERROR")
+	for _, r := range records {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Result, r.ID, r.Name, r.Error)
+	}
+	tw.Flush()
+}
+
+func (c *cli) printInterrupted(retired []*mackerel.Host, total int) {
+	c.printInfof("Interrupted after retiring %d out of %d hosts", len(retired), total)
+
+	for i, h := range retired {
+		c.printInfof("#%d Retired: id: %v, name: %v", i, h.ID, h.Name)
+	}
+}
+
+// newMkk initializes mkk.Mkk with the flags, which stops waiting for retries once ctx is done
+func (c *cli) newMkk(ctx context.Context) *mkk.Mkk {
+	client := mkk.NewMkk(token)
+	client.Retry.MaxAttempts = maxAttempts
+	client.Retry.Logf = c.printDebugf
+	client.Retry.Cancel = ctx.Done()
+	client.BulkRetireSize = bulkSize
+	client.AllowWorking = allowWorking
+	client.Protection = protection
+	client.MetadataNamespace = metadataNamespace
+	client.Clock = clock
+
+	if clock != nil {
+		c.printInfof("Evaluating filters as of %s", clock.Now().UTC().Format(time.RFC3339))
 	}
 
-	return ExitCodeOK
+	return client
+}
+
+func (c *cli) printRetries(client *mkk.Mkk) {
+	c.printDebugf("Retried API requests %d times", client.Retry.Retries())
 }
 
 func (c *cli) parseFlags(args []string) error {
-	flags := flag.NewFlagSet(Name, flag.ContinueOnError)
+	flags := c.newFlagSet(Name)
+	addSelectionFlags(flags)
+
+	flags.BoolVar(&dryRun, "dry-run", false, "")
+	flags.BoolVar(&dryRun, "d", false, "")
+
+	flags.BoolVar(&version, "version", false, "")
+	flags.BoolVar(&version, "v", false, "")
+
+	flags.BoolVar(&listFilters, "list-filters", false, "")
+
+	flags.BoolVar(&continueOnError, "continue-on-error", false, "")
+
+	addSnapshotFlags(flags)
+
+	return flags.Parse(args[1:])
+}
+
+// newFlagSet returns flag.FlagSet with the flags shared by all the commands
+func (c *cli) newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
 	flags.Usage = func() {
 		fmt.Fprint(c.errStream, usage)
 	}
 
+	// Only some of the commands register --from-snapshot
+	fromSnapshot = ""
+
 	flags.StringVar(&token, "token", os.Getenv(EnvMackerelToken), "")
 	flags.StringVar(&token, "t", os.Getenv(EnvMackerelToken), "")
 
-	flags.StringVar(&hosts, "hosts", "", "")
-	flags.StringVar(&hosts, "H", "", "")
+	flags.IntVar(&maxAttempts, "max-attempts", mkk.DefaultMaxAttempts, "")
 
-	flags.StringVar(&filters, "filters", "", "")
-	flags.StringVar(&filters, "F", "", "")
+	flags.IntVar(&bulkSize, "bulk-size", mkk.DefaultBulkRetireSize, "")
 
-	flags.BoolVar(&dryRun, "dry-run", false, "")
-	flags.BoolVar(&dryRun, "d", false, "")
+	flags.BoolVar(&allowWorking, "allow-working", false, "")
+
+	flags.StringVar(&protectFile, "protect-file", "", "")
+
+	flags.StringVar(&metadataNamespace, "metadata-namespace", mkk.DefaultMetadataNamespace, "")
+
+	flags.StringVar(&nowTime, "now", "", "")
 
 	flags.BoolVar(&quiet, "quiet", false, "")
 
 	flags.BoolVar(&debug, "debug", false, "")
 
-	flags.BoolVar(&version, "version", false, "")
-	flags.BoolVar(&version, "v", false, "")
+	flags.StringVar(&output, "output", OutputText, "")
 
-	return flags.Parse(args[1:])
+	return flags
+}
+
+// addSelectionFlags adds the flags to select hosts to retire
+func addSelectionFlags(flags *flag.FlagSet) {
+	flags.StringVar(&hosts, "hosts", "", "")
+	flags.StringVar(&hosts, "H", "", "")
+
+	flags.StringVar(&filters, "filters", "", "")
+	flags.StringVar(&filters, "F", "", "")
+
+	flags.IntVar(&maxRetire, "max-retire", 0, "")
+	flags.Float64Var(&maxRetirePercent, "max-retire-percent", 0, "")
 }
 
 func (c *cli) setupOutput() {
@@ -168,9 +360,21 @@ func (c *cli) setupOutput() {
 }
 
 func validateFlags() error {
-	if len(token) == 0 {
-		return fmt.Errorf("missing Mackerel API token\n"+
-			"Please set it via `%s` environment variable or `-t` option\n", EnvMackerelToken)
+	if err := validateCommonFlags(); err != nil {
+		return err
+	}
+
+	if clock != nil && !dryRun && len(fromSnapshot) == 0 {
+		return fmt.Errorf("--now requires --dry-run or --from-snapshot\n" +
+			"Hosts are never retired as of another time\n")
+	}
+
+	if maxRetire < 0 {
+		return fmt.Errorf("--max-retire must not be negative\n")
+	}
+
+	if maxRetirePercent < 0 || maxRetirePercent > 100 {
+		return fmt.Errorf("--max-retire-percent must be between 0 and 100\n")
 	}
 
 	if len(filters) == 0 {
@@ -181,6 +385,45 @@ func validateFlags() error {
 	return nil
 }
 
+func validateCommonFlags() error {
+	if len(token) == 0 && len(fromSnapshot) == 0 {
+		return fmt.Errorf("missing Mackerel API token\n"+
+			"Please set it via `%s` environment variable or `-t` option\n", EnvMackerelToken)
+	}
+
+	if maxAttempts < 1 {
+		return fmt.Errorf("--max-attempts must be greater than 0\n")
+	}
+
+	if bulkSize < 1 {
+		return fmt.Errorf("--bulk-size must be greater than 0\n")
+	}
+
+	if _, err := newRecordWriter(ioutil.Discard, output); err != nil {
+		return fmt.Errorf("%s\n", err)
+	}
+
+	clock = nil
+	if len(nowTime) > 0 {
+		t, err := mkk.ParseTime(nowTime)
+		if err != nil {
+			return fmt.Errorf("invalid --now: %s\n", err)
+		}
+		clock = mkk.FixedClock(time.Unix(t.Resolve(time.Now()), 0))
+	}
+
+	protection = nil
+	if len(protectFile) > 0 {
+		p, err := loadProtection(protectFile)
+		if err != nil {
+			return fmt.Errorf("error occurred while reading protect file: %s\n", err)
+		}
+		protection = p
+	}
+
+	return nil
+}
+
 func parseHosts(hosts string) (*mackerel.FindHostsParam, error) {
 	var p mackerel.FindHostsParam
 
@@ -195,44 +438,51 @@ func parseHosts(hosts string) (*mackerel.FindHostsParam, error) {
 	return &p, nil
 }
 
+// parseFilters parses the filters flag. It accepts either the pipeline form,
+// an ordered JSON array of mkk.FilterSpec, or the legacy form, a JSON object keyed by filter names.
+// Filters in the pipeline form are applied exactly in the given order.
 func parseFilters(filters string) ([]mkk.Filter, error) {
+	raw := bytes.TrimSpace([]byte(filters))
+
+	if len(raw) > 0 && raw[0] == '[' {
+		return parsePipelineFilters(raw)
+	}
+
+	return parseLegacyFilters(raw)
+}
+
+func parsePipelineFilters(raw []byte) ([]mkk.Filter, error) {
+	var specs []mkk.FilterSpec
+	if err := json.Unmarshal(raw, &specs); err != nil {
+		return nil, err
+	}
+
+	return mkk.NewFilters(specs)
+}
+
+// parseLegacyFilters parses the filters in the legacy form.
+// Filter names are sorted so that the filters are always applied in the same order.
+func parseLegacyFilters(raw []byte) ([]mkk.Filter, error) {
 	var arr map[string][]json.RawMessage
-	if err := json.Unmarshal([]byte(filters), &arr); err != nil {
+	if err := json.Unmarshal(raw, &arr); err != nil {
 		return nil, err
 	}
 
+	keys := make([]string, 0, len(arr))
+	for k := range arr {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
 	var fs []mkk.Filter
-	for k, v := range arr {
-		switch k {
-		case "GracePeriodFilter":
-			for i, attr := range v {
-				var f mkk.GracePeriodFilter
-				if err := json.Unmarshal(attr, &f); err != nil {
-					return nil, errors.Wrapf(err, "error occurred while unmarshaling %dth attribute of %s", i, k)
-				}
-
-				fs = append(fs, &f)
+	for _, k := range keys {
+		for i, attr := range arr[k] {
+			f, err := mkk.NewFilter(k, attr)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error occurred while unmarshaling %dth attribute of %s", i, k)
 			}
-		case "HostFilter":
-			for i, attr := range v {
-				var f mkk.HostFilter
-				if err := json.Unmarshal(attr, &f); err != nil {
-					return nil, errors.Wrapf(err, "error occurred while unmarshaling %dth attribute of %s", i, k)
-				}
-
-				fs = append(fs, &f)
-			}
-		case "MetricAbsenceFilter":
-			for i, attr := range v {
-				var f mkk.MetricAbsenceFilter
-				if err := json.Unmarshal(attr, &f); err != nil {
-					return nil, errors.Wrapf(err, "error occurred while unmarshaling %dth attribute of %s", i, k)
-				}
-
-				fs = append(fs, &f)
-			}
-		default:
-			return nil, fmt.Errorf("filter named `%s` does not exist", k)
+
+			fs = append(fs, f)
 		}
 	}
 
@@ -241,7 +491,7 @@ func parseFilters(filters string) ([]mkk.Filter, error) {
 
 func (c *cli) printDebugf(format string, args ...interface{}) {
 	if c.debug {
-		fmt.Fprintf(c.outStream, fmt.Sprintf("[mkk][DEBUG] %s\n", format), args...)
+		fmt.Fprintf(c.errStream, fmt.Sprintf("[mkk][DEBUG] %s\n", format), args...)
 	}
 }
 
@@ -250,22 +500,111 @@ func (c *cli) printErrorf(format string, args ...interface{}) {
 }
 
 func (c *cli) printInfof(format string, args ...interface{}) {
-	fmt.Fprintf(c.outStream, fmt.Sprintf("[mkk] %s\n", format), args...)
+	fmt.Fprintf(c.errStream, fmt.Sprintf("[mkk] %s\n", format), args...)
 }
 
 var usage = `mkk - Retire inactive Mackerel hosts
 
 Synopsis:
-  $ mkk --hosts '{"name":"hostName"}' --filters '{"MetricExistenceFilter":[{"name":"loadavg5","from":155891000,"to":155895000}]}'
+  $ mkk --hosts '{"name":"hostName"}' --filters '[{"type":"GracePeriodFilter","params":{"seconds":86400}},{"type":"MetricAbsenceFilter","params":{"name":"loadavg5","from":155891000,"to":155895000}}]'
 
-Options:
-  --debug        prints debug message
-  --dry-run, -d  runs mkk without actually retiring the hosts  
-  --filters, -F  specifies filters and its attributes in JSON
-  --help, -h     prints help
-  --hosts, -H    specifies query parameters to find hosts in JSON
-  --quiet        stops printing messages to stdout
-  --token, -t    specifies Mackerel API token
-  --version, -v  prints the current version
+  Filters are applied in the order of the array.
+
+  Times such as from and to accept epoch seconds, "now", durations relative to now like "-24h" or "-7d"
+  and ISO-8601 timestamps. GracePeriodFilter accepts a period like "72h" instead of seconds.
+
+  $ mkk --filters '[{"type":"GracePeriodFilter","params":{"period":"72h"}},{"type":"MetricAbsenceFilter","params":{"name":"loadavg5","from":"-24h","to":"now"}}]'
+
+  AllOf, AnyOf and Not combine other filters. AllOf and AnyOf take an array of filters
+  and Not takes a single filter as their params.
+
+  $ mkk --filters '[{"type":"AnyOf","params":[{"type":"MetricAbsenceFilter","params":{"name":"loadavg5","from":155891000}},{"type":"MetricAbsenceFilter","params":{"name":"custom.heartbeat","from":155894400}}]},{"type":"Not","params":{"type":"HostFilter","params":{"type":"unknown"}}}]'
+
+  The legacy form below is still accepted and applies the filters in alphabetical order of their names.
 
+  $ mkk --hosts '{"name":"hostName"}' --filters '{"MetricAbsenceFilter":[{"name":"loadavg5","from":155891000,"to":155895000}]}'
+
+Commands:
+  $ mkk plan [options] -o plan.json
+    writes the hosts selected by --hosts and --filters to a plan file along with the reasons
+
+  $ mkk apply [options] plan.json
+    retires the hosts in the plan file after checking that they have not changed since the plan is made
+
+  $ mkk run --config mkk.yaml <job>|--all
+    runs the named job or all the jobs defined in the config file written in YAML or JSON
+
+      jobs:
+        - name: stale-web
+          hosts: {"service": "prod", "roles": ["web"]}
+          filters:
+            - {"type": "GracePeriodFilter", "params": {"period": "24h"}}
+            - {"type": "MetricAbsenceFilter", "params": {"name": "loadavg5", "from": "-24h"}}
+          dryRun: true
+          maxRetire: 10
+          maxRetirePercent: 5
+      protect:
+        roles: ["prod:db", "*:bastion"]
+
+  $ mkk explain --host <id> --filters '[...]'
+    prints the verdict of every filter on the host along with the reason
+
+  $ mkk snapshot [options] -o hosts.json
+    writes the hosts found by --hosts and the API responses --filters read to a snapshot file
+
+  $ mkk --from-snapshot hosts.json --filters '[...]'
+    evaluates the filters against the snapshot without any requests to Mackerel and without retiring any hosts
+    The snapshot is evaluated at the time it is taken, and --hosts is ignored
+
+Options:
+  --all              runs all the jobs in the config file with run command
+  --allow-working    allows retiring hosts whose status is working, which are skipped by default
+  --bulk-size        specifies the number of hosts retired by a single bulk-retire request (default: 50)
+                     1 retires the hosts one by one
+                     When a bulk-retire request fails, the hosts in the batch are retired one by one,
+                     and mkk stops after that batch unless --continue-on-error is given
+  --config, -c       specifies the config file for run command
+  --continue-on-error
+                     tries to retire every host even if some of them fail and prints a summary at the end
+  --debug            prints debug message
+  --dry-run, -d      runs mkk without actually retiring the hosts
+  --filters, -F      specifies filters and its attributes in JSON, applied in the given order
+  --from-snapshot    evaluates the filters against the snapshot file written by snapshot command
+  --help, -h         prints help
+  --host             specifies the host ID for explain command
+  --hosts, -H        specifies query parameters to find hosts in JSON
+  --list-filters     prints the names of the available filters
+  --max-attempts     specifies how many times an API request is attempted on transient errors (default: 5)
+  --metadata-namespace
+                     specifies the namespace of the host metadata checked before retiring each host (default: mackerel-killer)
+                     Hosts whose metadata has {"protect": true} or a future {"retire_after": <epoch seconds>} are skipped,
+                     and an empty value disables the check
+  --now              evaluates the filters as if it were the given time, which is epoch seconds,
+                     an ISO-8601 timestamp or a duration relative to now like -7d (default: now)
+                     It is only allowed with --dry-run, --from-snapshot, plan, explain and snapshot command
+  --out, -o          specifies the plan or snapshot file written by plan or snapshot command (default: stdout)
+  --max-retire       aborts without retiring any hosts when more than N hosts are selected
+  --max-retire-percent
+                     aborts without retiring any hosts when more than P percent of the hosts found
+                     by --hosts are selected
+  --output           specifies the format of the hosts written to stdout: text, json, jsonl, csv or table (default: text)
+                     Each host has id, name, type, status, roles, createdAt and its result,
+                     which is either selected, retired, failed or skipped along with the error
+  --protect-file     specifies the file listing the hosts never retired whatever the filters say
+                     in YAML or JSON, e.g. {"ids": ["abcdefg"], "names": ["^db-"], "roles": ["prod:db"]}
+                     Names are regular expressions and roles may be glob patterns like *:bastion
+  --quiet            stops printing messages to stderr
+  --token, -t        specifies Mackerel API token
+  --version, -v      prints the current version
+
+
+Exit Codes:
+  0  succeeded
+  1  failed with an error
+  2  failed to parse flags
+  3  invalid flags, hosts, filters or config file
+  4  aborted by --max-retire or --max-retire-percent without retiring any hosts
+  5  aborted by apply command because the plan is out of date
+  6  interrupted by SIGINT or SIGTERM, a second signal terminates mkk immediately
+  7  some of the hosts were retired but the others failed to retire
 `
@@ -2,9 +2,21 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/shuheiktgw/mackerel-killer/pkg/mkk"
+
+	"github.com/mackerelio/mackerel-client-go"
 )
 
 func TestCLI_Run(t *testing.T) {
@@ -20,18 +32,84 @@ func TestCLI_Run(t *testing.T) {
 			expectedErrStream: "",
 			expectedExitCode:  ExitCodeOK,
 		},
+		{
+			command:           "mkk --list-filters",
+			expectedOutStream: "AllOf\nAnyOf\nGracePeriodFilter\n",
+			expectedErrStream: "",
+			expectedExitCode:  ExitCodeOK,
+		},
+		{
+			command:           "mkk apply -t aqbc",
+			expectedOutStream: "",
+			expectedErrStream: "missing a plan file",
+			expectedExitCode:  ExitCodeInvalidFlagError,
+		},
+		{
+			command:           "mkk apply -t aqbc not-exist.json",
+			expectedOutStream: "",
+			expectedErrStream: "Error occurred while reading a plan",
+			expectedExitCode:  ExitCodeError,
+		},
+		{
+			command:           `mkk plan -t aqbc -H {}`,
+			expectedOutStream: "",
+			expectedErrStream: "missing filters",
+			expectedExitCode:  ExitCodeInvalidFlagError,
+		},
+		{
+			command:           "mkk run -t aqbc job",
+			expectedOutStream: "",
+			expectedErrStream: "missing config file",
+			expectedExitCode:  ExitCodeInvalidFlagError,
+		},
+		{
+			command:           "mkk run -t aqbc --config mkk.yaml --all job",
+			expectedOutStream: "",
+			expectedErrStream: "specify either a job name or `--all`",
+			expectedExitCode:  ExitCodeInvalidFlagError,
+		},
 		{
 			command:           `mkk -t aqbc -H {}`,
 			expectedOutStream: "",
 			expectedErrStream: "missing filters",
 			expectedExitCode:  ExitCodeInvalidFlagError,
 		},
+		{
+			command:           `mkk -t aqbc -H {} --max-retire-percent 150 -F [{"type":"HostFilter"}]`,
+			expectedOutStream: "",
+			expectedErrStream: "--max-retire-percent must be between 0 and 100",
+			expectedExitCode:  ExitCodeInvalidFlagError,
+		},
+		{
+			command:           `mkk -t aqbc -H {} --now yesterday -F [{"type":"HostFilter"}]`,
+			expectedOutStream: "",
+			expectedErrStream: "invalid --now",
+			expectedExitCode:  ExitCodeInvalidFlagError,
+		},
+		{
+			command:           `mkk -t aqbc -H {} --now -7d -F [{"type":"HostFilter"}]`,
+			expectedOutStream: "",
+			expectedErrStream: "--now requires --dry-run or --from-snapshot",
+			expectedExitCode:  ExitCodeInvalidFlagError,
+		},
+		{
+			command:           "mkk apply -t aqbc --now -7d plan.json",
+			expectedOutStream: "",
+			expectedErrStream: "--now cannot be used with apply command",
+			expectedExitCode:  ExitCodeInvalidFlagError,
+		},
 		{
 			command:           `mkk -t aqbc -H {} -F {"UnknownFilter":[{"name":"loadavg5"}]}`,
 			expectedOutStream: "",
 			expectedErrStream: "filter named `UnknownFilter` does not exist",
 			expectedExitCode:  ExitCodeInvalidFlagError,
 		},
+		{
+			command:           `mkk -t aqbc -H {} -F [{"type":"UnknownFilter","params":{"name":"loadavg5"}}]`,
+			expectedOutStream: "",
+			expectedErrStream: "filter named `UnknownFilter` does not exist",
+			expectedExitCode:  ExitCodeInvalidFlagError,
+		},
 	}
 
 	for i, tc := range cases {
@@ -54,3 +132,482 @@ func TestCLI_Run(t *testing.T) {
 		}
 	}
 }
+
+func TestParseFilters(t *testing.T) {
+	cases := []struct {
+		title   string
+		filters string
+		want    []mkk.Filter
+	}{
+		{
+			title:   "Pipeline form keeps the given order",
+			filters: `[{"type":"MetricAbsenceFilter","params":{"name":"loadavg5","from":1,"to":2}},{"type":"HostFilter","params":{"type":"agent"}},{"type":"GracePeriodFilter","params":{"seconds":100}}]`,
+			want: []mkk.Filter{
+				&mkk.MetricAbsenceFilter{Name: "loadavg5", From: 1, To: 2},
+				&mkk.HostFilter{Type: "agent"},
+				&mkk.GracePeriodFilter{Seconds: 100},
+			},
+		},
+		{
+			title:   "Legacy form is sorted by filter names",
+			filters: `{"MetricAbsenceFilter":[{"name":"loadavg5","from":1,"to":2}],"HostFilter":[{"type":"agent"}],"GracePeriodFilter":[{"seconds":100},{"seconds":200}]}`,
+			want: []mkk.Filter{
+				&mkk.GracePeriodFilter{Seconds: 100},
+				&mkk.GracePeriodFilter{Seconds: 200},
+				&mkk.HostFilter{Type: "agent"},
+				&mkk.MetricAbsenceFilter{Name: "loadavg5", From: 1, To: 2},
+			},
+		},
+		{
+			title:   "Nested combinators",
+			filters: `[{"type":"AnyOf","params":[{"type":"MetricAbsenceFilter","params":{"name":"loadavg5","from":1}},{"type":"MetricAbsenceFilter","params":{"name":"custom.heartbeat","from":2}}]},{"type":"Not","params":{"type":"HostFilter","params":{"type":"unknown"}}}]`,
+			want: []mkk.Filter{
+				&mkk.AnyOf{Filters: []mkk.Filter{
+					&mkk.MetricAbsenceFilter{Name: "loadavg5", From: 1},
+					&mkk.MetricAbsenceFilter{Name: "custom.heartbeat", From: 2},
+				}},
+				&mkk.Not{Filter: &mkk.HostFilter{Type: "unknown"}},
+			},
+		},
+		{
+			title:   "Interface filter",
+			filters: `[{"type":"InterfaceFilter","params":{"names":["eth*"],"include":["10.20.0.0/16"],"exclude":["10.20.1.0/24"]}}]`,
+			want: []mkk.Filter{
+				&mkk.InterfaceFilter{Names: []string{"eth*"}, Include: []string{"10.20.0.0/16"}, Exclude: []string{"10.20.1.0/24"}},
+			},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(tc.title, func(t *testing.T) {
+			fs, err := parseFilters(tc.filters)
+			if err != nil {
+				t.Fatalf("#%d parseFilters returned error: %v", i, err)
+			}
+
+			if got, want := fs, tc.want; !reflect.DeepEqual(got, want) {
+				t.Errorf("#%d invalid filters: got: %v, want: %v", i, got, want)
+			}
+		})
+	}
+}
+
+// setupMkk sets up a test HTTP server along with mkk.Mkk talking to it
+func setupMkk() (*mkk.Mkk, *http.ServeMux, func()) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+
+	client := mkk.NewMkk("")
+	u, _ := url.Parse(server.URL + "/")
+	client.Client.BaseURL = u
+
+	return client, mux, server.Close
+}
+
+func TestCLI_Retire_Interrupted(t *testing.T) {
+	client, mux, teardown := setupMkk()
+	defer teardown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client.BulkRetireSize = 1
+
+	// Interrupted while retiring the first host
+	mux.HandleFunc("/api/v0/hosts/", func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		fmt.Fprint(w, `{"success": true}`)
+	})
+
+	hs := []*mackerel.Host{{ID: "a", Name: "a"}, {ID: "b", Name: "b"}, {ID: "c", Name: "c"}}
+
+	outStream := new(bytes.Buffer)
+	errStream := new(bytes.Buffer)
+	c := cli{outStream: outStream, errStream: errStream}
+
+	w, _ := newRecordWriter(outStream, OutputText)
+	if got, want := c.retire(ctx, client, &job{}, hs, w), ExitCodeInterrupted; got != want {
+		t.Errorf("invalid exit code: got: %v, want: %v", got, want)
+	}
+
+	if got, want := outStream.String(), "#0 Retired: id: a, name: a\n"; got != want {
+		t.Errorf("invalid outStream: got: %q, want: %q", got, want)
+	}
+
+	if got, want := errStream.String(), "Interrupted after retiring 1 out of 3 hosts"; !strings.Contains(got, want) {
+		t.Errorf("invalid errStream: got: %v, want: %v", got, want)
+	}
+}
+
+func TestCLI_Retire_ContinueOnError(t *testing.T) {
+	cases := []struct {
+		title             string
+		failures          map[string]bool
+		continueOnError   bool
+		expectedOutStream string
+		expectedErrStream string
+		expectedExitCode  int
+	}{
+		{
+			title:             "Stops at the first failure",
+			failures:          map[string]bool{"b": true},
+			expectedOutStream: "#0 Retired: id: a, name: a\n#1 Failed: id: b, name: b: API request failed: 500 Internal Server Error\n",
+			expectedExitCode:  ExitCodePartialSuccess,
+		},
+		{
+			title:             "Stops at the first host",
+			failures:          map[string]bool{"a": true},
+			expectedOutStream: "#0 Failed: id: a, name: a: API request failed: 500 Internal Server Error\n",
+			expectedExitCode:  ExitCodeError,
+		},
+		{
+			title:             "Continues on error",
+			failures:          map[string]bool{"b": true},
+			continueOnError:   true,
+			expectedOutStream: "#0 Retired: id: a, name: a\n#1 Failed: id: b, name: b: API request failed: 500 Internal Server Error\n#2 Retired: id: c, name: c\n",
+			expectedErrStream: "Retired 2 hosts, failed to retire 1 hosts",
+			expectedExitCode:  ExitCodePartialSuccess,
+		},
+		{
+			title:             "All hosts fail",
+			failures:          map[string]bool{"a": true, "b": true, "c": true},
+			continueOnError:   true,
+			expectedErrStream: "Retired 0 hosts, failed to retire 3 hosts",
+			expectedExitCode:  ExitCodeError,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(tc.title, func(t *testing.T) {
+			client, mux, teardown := setupMkk()
+			defer teardown()
+
+			client.Retry.MaxAttempts = 1
+			client.BulkRetireSize = 1
+
+			mux.HandleFunc("/api/v0/hosts/", func(w http.ResponseWriter, r *http.Request) {
+				id := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v0/hosts/"), "/")[0]
+				if tc.failures[id] {
+					w.WriteHeader(http.StatusInternalServerError)
+				}
+				fmt.Fprint(w, `{}`)
+			})
+
+			hs := []*mackerel.Host{{ID: "a", Name: "a"}, {ID: "b", Name: "b"}, {ID: "c", Name: "c"}}
+
+			outStream := new(bytes.Buffer)
+			errStream := new(bytes.Buffer)
+			c := cli{outStream: outStream, errStream: errStream}
+
+			w, _ := newRecordWriter(outStream, OutputText)
+			code := c.retire(context.Background(), client, &job{continueOnError: tc.continueOnError}, hs, w)
+
+			if got, want := code, tc.expectedExitCode; got != want {
+				t.Errorf("#%d invalid exit code: got: %v, want: %v", i, got, want)
+			}
+
+			if got, want := outStream.String(), tc.expectedOutStream; !strings.Contains(got, want) {
+				t.Errorf("#%d invalid outStream: got: %q, want: %q", i, got, want)
+			}
+
+			if got, want := errStream.String(), tc.expectedErrStream; !strings.Contains(got, want) {
+				t.Errorf("#%d invalid errStream: got: %v, want: %v", i, got, want)
+			}
+		})
+	}
+}
+
+func TestCLI_Retire_Bulk(t *testing.T) {
+	client, mux, teardown := setupMkk()
+	defer teardown()
+
+	client.BulkRetireSize = 2
+
+	var bulkRequests, singleRequests int
+	mux.HandleFunc("/api/v0/hosts/bulk-retire", func(w http.ResponseWriter, r *http.Request) {
+		bulkRequests++
+		fmt.Fprint(w, `{"success": true}`)
+	})
+	mux.HandleFunc("/api/v0/hosts/", func(w http.ResponseWriter, r *http.Request) {
+		singleRequests++
+		fmt.Fprint(w, `{"success": true}`)
+	})
+
+	hs := []*mackerel.Host{{ID: "a", Name: "a"}, {ID: "b", Name: "b"}, {ID: "c", Name: "c"}}
+
+	outStream := new(bytes.Buffer)
+	c := cli{outStream: outStream, errStream: new(bytes.Buffer)}
+
+	w, _ := newRecordWriter(outStream, OutputText)
+	if got, want := c.retire(context.Background(), client, &job{}, hs, w), ExitCodeOK; got != want {
+		t.Errorf("invalid exit code: got: %v, want: %v", got, want)
+	}
+
+	want := "#0 Retired: id: a, name: a\n#1 Retired: id: b, name: b\n#2 Retired: id: c, name: c\n"
+	if got := outStream.String(); got != want {
+		t.Errorf("invalid outStream: got: %q, want: %q", got, want)
+	}
+
+	if bulkRequests != 1 || singleRequests != 1 {
+		t.Errorf("invalid number of requests: bulk: %v, single: %v", bulkRequests, singleRequests)
+	}
+}
+
+func TestCLI_Retire_ZeroBulkSize(t *testing.T) {
+	client, mux, teardown := setupMkk()
+	defer teardown()
+
+	// Mkk built as a library may leave BulkRetireSize unset
+	client.BulkRetireSize = 0
+
+	var bulkRequests int32
+	mux.HandleFunc("/api/v0/hosts/bulk-retire", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&bulkRequests, 1)
+		fmt.Fprint(w, `{"success": true}`)
+	})
+
+	hs := []*mackerel.Host{{ID: "a", Name: "a"}, {ID: "b", Name: "b"}}
+
+	c := cli{outStream: new(bytes.Buffer), errStream: new(bytes.Buffer)}
+	w, _ := newRecordWriter(new(bytes.Buffer), OutputText)
+
+	done := make(chan int, 1)
+	go func() {
+		done <- c.retire(context.Background(), client, &job{}, hs, w)
+	}()
+
+	select {
+	case code := <-done:
+		if got, want := code, ExitCodeOK; got != want {
+			t.Errorf("invalid exit code: got: %v, want: %v", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("cli.retire did not return with BulkRetireSize 0")
+	}
+
+	if got, want := atomic.LoadInt32(&bulkRequests), int32(1); got != want {
+		t.Errorf("invalid number of bulk-retire requests: got: %v, want: %v", got, want)
+	}
+}
+
+func TestCLI_Retire_Working(t *testing.T) {
+	client, mux, teardown := setupMkk()
+	defer teardown()
+
+	client.BulkRetireSize = 1
+
+	mux.HandleFunc("/api/v0/hosts/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v0/hosts/a/retire" {
+			t.Errorf("working host is not supposed to be retired")
+		}
+		fmt.Fprint(w, `{"success": true}`)
+	})
+
+	hs := []*mackerel.Host{
+		{ID: "a", Name: "a", Status: mackerel.HostStatusWorking},
+		{ID: "b", Name: "b", Status: mackerel.HostStatusPoweroff},
+	}
+
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	c := cli{outStream: outStream, errStream: errStream}
+
+	w, _ := newRecordWriter(outStream, OutputText)
+	if got, want := c.retire(context.Background(), client, &job{continueOnError: true}, hs, w), ExitCodeOK; got != want {
+		t.Errorf("invalid exit code: got: %v, want: %v", got, want)
+	}
+
+	want := "#0 Skipped: id: a, name: a: refused to retire: status is working\n#1 Retired: id: b, name: b\n"
+	if got := outStream.String(); got != want {
+		t.Errorf("invalid outStream: got: %q, want: %q", got, want)
+	}
+
+	if got, want := errStream.String(), "Retired 1 hosts, failed to retire 0 hosts, skipped 1 hosts"; !strings.Contains(got, want) {
+		t.Errorf("invalid errStream: got: %q, want: %q", got, want)
+	}
+}
+
+func TestCLI_Retire_Protected(t *testing.T) {
+	client, mux, teardown := setupMkk()
+	defer teardown()
+
+	client.BulkRetireSize = 1
+	client.Protection = &mkk.Protection{Names: []string{"^db-"}}
+
+	mux.HandleFunc("/api/v0/hosts/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v0/hosts/a/retire" {
+			t.Errorf("protected host is not supposed to be retired")
+		}
+		fmt.Fprint(w, `{"success": true}`)
+	})
+
+	hs := []*mackerel.Host{{ID: "a", Name: "db-1"}, {ID: "b", Name: "web-1"}}
+
+	outStream := new(bytes.Buffer)
+	c := cli{outStream: outStream, errStream: new(bytes.Buffer)}
+
+	w, _ := newRecordWriter(outStream, OutputText)
+	if got, want := c.retire(context.Background(), client, &job{}, hs, w), ExitCodeOK; got != want {
+		t.Errorf("invalid exit code: got: %v, want: %v", got, want)
+	}
+
+	want := "#0 Skipped: id: a, name: db-1: protected: name \"db-1\" matches \"^db-\"\n#1 Retired: id: b, name: web-1\n"
+	if got := outStream.String(); got != want {
+		t.Errorf("invalid outStream: got: %q, want: %q", got, want)
+	}
+}
+
+func TestCLI_Verify(t *testing.T) {
+	var cases = []struct {
+		title    string
+		status   int
+		response string
+		want     int
+	}{
+		{
+			title:    "Host has not changed",
+			status:   http.StatusOK,
+			response: `{"host": {"id":"a","name":"a","type":"unknown","status":"standby"}}`,
+			want:     ExitCodeOK,
+		},
+		{
+			title:    "Host has changed",
+			status:   http.StatusOK,
+			response: `{"host": {"id":"a","name":"a","type":"unknown","status":"working"}}`,
+			want:     ExitCodePlanMismatch,
+		},
+		{
+			title:    "Host does not exist",
+			status:   http.StatusNotFound,
+			response: `{"error": {"message": "Host not found"}}`,
+			want:     ExitCodePlanMismatch,
+		},
+		{
+			title:    "Unauthorized",
+			status:   http.StatusUnauthorized,
+			response: `{"error": {"message": "Authentication failed"}}`,
+			want:     ExitCodeError,
+		},
+		{
+			title:    "Server error",
+			status:   http.StatusInternalServerError,
+			response: `{"error": {"message": "Internal server error"}}`,
+			want:     ExitCodeError,
+		},
+	}
+
+	p := &mkk.Plan{Hosts: []*mkk.PlannedHost{{ID: "a", Name: "a", Type: "unknown", Status: "standby", Roles: []string{}}}}
+
+	for i, tc := range cases {
+		t.Run(tc.title, func(t *testing.T) {
+			client, mux, teardown := setupMkk()
+			defer teardown()
+
+			client.Retry.MaxAttempts = 1
+
+			mux.HandleFunc("/api/v0/hosts/a", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				fmt.Fprint(w, tc.response)
+			})
+
+			errStream := new(bytes.Buffer)
+			c := cli{outStream: new(bytes.Buffer), errStream: errStream}
+
+			hs, code := c.verify(context.Background(), client, p)
+			if got, want := code, tc.want; got != want {
+				t.Fatalf("#%d invalid exit code: got: %v, want: %v, errStream: %q", i, got, want, errStream.String())
+			}
+
+			if tc.want == ExitCodeOK && len(hs) != 1 {
+				t.Errorf("#%d invalid number of hosts: got: %v, want: 1", i, len(hs))
+			}
+
+			if tc.want == ExitCodeError && strings.Contains(errStream.String(), "out of date") {
+				t.Errorf("#%d plan is not supposed to be reported as out of date: %q", i, errStream.String())
+			}
+		})
+	}
+}
+
+func TestCLI_RunJob_SharedWriter(t *testing.T) {
+	client, mux, teardown := setupMkk()
+	defer teardown()
+
+	mux.HandleFunc("/api/v0/hosts", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("service") {
+		case "web":
+			fmt.Fprint(w, `{"hosts": [{"id":"a","name":"web-1","type":"agent","status":"standby"}]}`)
+		case "db":
+			fmt.Fprint(w, `{"hosts": [{"id":"b","name":"db-1","type":"agent","status":"standby"}]}`)
+		default:
+			fmt.Fprint(w, `{"hosts": []}`)
+		}
+	})
+
+	js := []*job{
+		{name: "web", param: &mackerel.FindHostsParam{Service: "web"}, dryRun: true},
+		{name: "db", param: &mackerel.FindHostsParam{Service: "db"}, dryRun: true},
+		{name: "none", param: &mackerel.FindHostsParam{Service: "none"}, dryRun: true},
+	}
+
+	outStream := new(bytes.Buffer)
+	c := cli{outStream: outStream, errStream: new(bytes.Buffer)}
+
+	w, _ := newRecordWriter(outStream, OutputJSON)
+	for _, j := range js {
+		if got, want := c.runJob(context.Background(), client, j, w), ExitCodeOK; got != want {
+			t.Fatalf("invalid exit code of job %s: got: %v, want: %v", j.name, got, want)
+		}
+	}
+	c.flushRecords(w)
+
+	var records []*hostRecord
+	if err := json.Unmarshal(outStream.Bytes(), &records); err != nil {
+		t.Fatalf("outStream is not a single JSON array: %v: %q", err, outStream.String())
+	}
+
+	var got []string
+	for _, r := range records {
+		got = append(got, r.Job+":"+r.ID)
+	}
+
+	if want := []string{"web:a", "db:b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid records: got: %v, want: %v", got, want)
+	}
+}
+
+func TestCLI_RunJob_Now(t *testing.T) {
+	client, mux, teardown := setupMkk()
+	defer teardown()
+
+	client.Clock = mkk.FixedClock(time.Unix(1558915200, 0))
+
+	var retires int32
+	mux.HandleFunc("/api/v0/hosts", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"hosts": [{"id":"a","name":"web-1","type":"agent","status":"standby"}]}`)
+	})
+	mux.HandleFunc("/api/v0/hosts/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			atomic.AddInt32(&retires, 1)
+		}
+		fmt.Fprint(w, `{}`)
+	})
+
+	outStream := new(bytes.Buffer)
+	c := cli{outStream: outStream, errStream: new(bytes.Buffer)}
+
+	// The job is not a dry run, but hosts are never retired as of another time
+	w, _ := newRecordWriter(outStream, OutputText)
+	j := &job{name: "web", param: &mackerel.FindHostsParam{}}
+	if got, want := c.runJob(context.Background(), client, j, w), ExitCodeOK; got != want {
+		t.Fatalf("invalid exit code: got: %v, want: %v", got, want)
+	}
+	c.flushRecords(w)
+
+	if got := atomic.LoadInt32(&retires); got != 0 {
+		t.Errorf("hosts are retired with Clock: got: %v requests", got)
+	}
+
+	if got, want := outStream.String(), "#0 id: a, name: web-1"; !strings.Contains(got, want) {
+		t.Errorf("invalid outStream: got: %q, want: %q", got, want)
+	}
+}
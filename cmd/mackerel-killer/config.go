@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+
+	"github.com/shuheiktgw/mackerel-killer/pkg/mkk"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+var (
+	configFile string
+	allJobs    bool
+)
+
+// config is the content of the config file given by --config
+type config struct {
+	Jobs    []*jobConfig    `json:"jobs"`
+	Protect *mkk.Protection `json:"protect"`
+}
+
+// jobConfig defines a named job in the config file
+type jobConfig struct {
+	Name             string                  `json:"name"`
+	Hosts            mackerel.FindHostsParam `json:"hosts"`
+	Filters          []mkk.FilterSpec        `json:"filters"`
+	DryRun           bool                    `json:"dryRun"`
+	MaxRetire        int                     `json:"maxRetire"`
+	MaxRetirePercent float64                 `json:"maxRetirePercent"`
+	ContinueOnError  bool                    `json:"continueOnError"`
+}
+
+// loadConfig reads the config file written either in YAML or JSON
+func loadConfig(path string) (*config, error) {
+	var conf config
+	if err := readYAMLFile(path, &conf); err != nil {
+		return nil, err
+	}
+
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+
+	return &conf, nil
+}
+
+// loadProtection reads the protection list file given by --protect-file written either in YAML or JSON
+func loadProtection(path string) (*mkk.Protection, error) {
+	var p mkk.Protection
+	if err := readYAMLFile(path, &p); err != nil {
+		return nil, err
+	}
+
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// readYAMLFile reads the file written either in YAML or JSON into v
+func readYAMLFile(path string, v interface{}) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	// JSON is a subset of YAML, so both are read as YAML and converted to JSON
+	// in order to reuse the JSON representation of filters
+	var raw interface{}
+	if err := yaml.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	converted, err := toJSONValue(raw)
+	if err != nil {
+		return err
+	}
+
+	j, err := json.Marshal(converted)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(j, v)
+}
+
+// toJSONValue converts maps decoded by yaml.v2 into the ones encoding/json can handle
+func toJSONValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			ks, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("non string key %v is not supported", k)
+			}
+
+			c, err := toJSONValue(e)
+			if err != nil {
+				return nil, err
+			}
+			m[ks] = c
+		}
+		return m, nil
+	case []interface{}:
+		a := make([]interface{}, len(v))
+		for i, e := range v {
+			c, err := toJSONValue(e)
+			if err != nil {
+				return nil, err
+			}
+			a[i] = c
+		}
+		return a, nil
+	default:
+		return v, nil
+	}
+}
+
+func (c *config) validate() error {
+	if c.Protect != nil {
+		if err := c.Protect.Validate(); err != nil {
+			return errors.Wrap(err, "invalid protect")
+		}
+	}
+
+	names := make(map[string]bool)
+
+	for i, jc := range c.Jobs {
+		if len(jc.Name) == 0 {
+			return fmt.Errorf("%dth job does not have a name", i)
+		}
+
+		if names[jc.Name] {
+			return fmt.Errorf("job named `%s` is defined more than once", jc.Name)
+		}
+		names[jc.Name] = true
+
+		if len(jc.Filters) == 0 {
+			return fmt.Errorf("job named `%s` does not have filters", jc.Name)
+		}
+
+		if jc.MaxRetire < 0 {
+			return fmt.Errorf("maxRetire of job named `%s` must not be negative", jc.Name)
+		}
+
+		if jc.MaxRetirePercent < 0 || jc.MaxRetirePercent > 100 {
+			return fmt.Errorf("maxRetirePercent of job named `%s` must be between 0 and 100", jc.Name)
+		}
+	}
+
+	return nil
+}
+
+// findJob returns the job config with the given name or nil
+func (c *config) findJob(name string) *jobConfig {
+	for _, jc := range c.Jobs {
+		if jc.Name == name {
+			return jc
+		}
+	}
+
+	return nil
+}
+
+// build builds job from the job config
+func (jc *jobConfig) build() (*job, error) {
+	fs, err := mkk.NewFilters(jc.Filters)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error occurred while building filters of job named `%s`", jc.Name)
+	}
+
+	param := jc.Hosts
+
+	return &job{
+		name:    jc.Name,
+		param:   &param,
+		filters: fs,
+		dryRun:  jc.DryRun,
+		limit:   mkk.RetireLimit{Max: jc.MaxRetire, MaxPercent: jc.MaxRetirePercent},
+
+		continueOnError: jc.ContinueOnError,
+	}, nil
+}
+
+// runConfig runs the named job or all the jobs defined in the config file
+func (c *cli) runConfig(ctx context.Context, args []string) int {
+	flags := c.newFlagSet(Name + " run")
+
+	flags.StringVar(&configFile, "config", "", "")
+	flags.StringVar(&configFile, "c", "", "")
+
+	flags.BoolVar(&allJobs, "all", false, "")
+
+	flags.BoolVar(&dryRun, "dry-run", false, "")
+	flags.BoolVar(&dryRun, "d", false, "")
+
+	flags.BoolVar(&continueOnError, "continue-on-error", false, "")
+
+	if err := flags.Parse(args[1:]); err != nil {
+		c.printErrorf("Error occurred while parsing flags: %s", err)
+		return ExitCodeParseFlagError
+	}
+
+	c.setupOutput()
+
+	if err := validateCommonFlags(); err != nil {
+		c.printErrorf("Flag validation fails: %s", err)
+		return ExitCodeInvalidFlagError
+	}
+
+	if len(configFile) == 0 {
+		c.printErrorf("Flag validation fails: missing config file\nPlease set it via `--config` option\n")
+		return ExitCodeInvalidFlagError
+	}
+
+	if allJobs == (flags.NArg() == 1) || flags.NArg() > 1 {
+		c.printErrorf("Flag validation fails: specify either a job name or `--all`\nUsage: %s run [options] <job>|--all\n", Name)
+		return ExitCodeInvalidFlagError
+	}
+
+	conf, err := loadConfig(configFile)
+	if err != nil {
+		c.printErrorf("Error occurred while reading config file: %s", err)
+		return ExitCodeInvalidFlagError
+	}
+
+	jcs := conf.Jobs
+	if !allJobs {
+		jc := conf.findJob(flags.Arg(0))
+		if jc == nil {
+			c.printErrorf("job named `%s` does not exist in %s", flags.Arg(0), configFile)
+			return ExitCodeInvalidFlagError
+		}
+		jcs = []*jobConfig{jc}
+	}
+
+	protection = protection.Merge(conf.Protect)
+
+	// Build all the jobs first so that a broken job does not stop the others halfway
+	js := make([]*job, 0, len(jcs))
+	for _, jc := range jcs {
+		j, err := jc.build()
+		if err != nil {
+			c.printErrorf("%s", err)
+			return ExitCodeInvalidFlagError
+		}
+
+		// --dry-run and --continue-on-error take precedence over the config file
+		j.dryRun = j.dryRun || dryRun
+		j.continueOnError = j.continueOnError || continueOnError
+
+		if clock != nil && !j.dryRun {
+			c.printErrorf("Flag validation fails: --now requires --dry-run, but job `%s` retires hosts\n", j.name)
+			return ExitCodeInvalidFlagError
+		}
+
+		js = append(js, j)
+	}
+
+	client := c.newMkk(ctx)
+	defer c.printRetries(client)
+
+	// All the jobs write to a single writer so that stdout has one JSON array or one CSV header
+	w := c.newRecordWriter()
+	defer c.flushRecords(w)
+
+	result := ExitCodeOK
+	for _, j := range js {
+		if ctx.Err() != nil {
+			c.printInfof("Interrupted before running job `%s`", j.name)
+			return ExitCodeInterrupted
+		}
+
+		c.printInfof("Running job `%s`", j.name)
+
+		if code := c.runJob(ctx, client, j, w); code != ExitCodeOK {
+			c.printErrorf("Job `%s` failed", j.name)
+
+			if result == ExitCodeOK {
+				result = code
+			}
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/shuheiktgw/mackerel-killer/pkg/mkk"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+func TestLoadConfig(t *testing.T) {
+	cases := []struct {
+		title   string
+		file    string
+		content string
+	}{
+		{
+			title: "YAML",
+			file:  "mkk.yaml",
+			content: `
+jobs:
+  - name: web
+    hosts:
+      service: prod
+      roles: [web]
+    filters:
+      - type: HostFilter
+        params:
+          type: agent
+      - type: GracePeriodFilter
+        params:
+          seconds: 86400
+    dryRun: true
+    maxRetire: 10
+    maxRetirePercent: 5.5
+protect:
+  ids: [abcdefg]
+  roles: ["prod:db"]
+`,
+		},
+		{
+			title: "JSON",
+			file:  "mkk.json",
+			content: `{"jobs": [{"name": "web", "hosts": {"service": "prod", "roles": ["web"]},
+"filters": [{"type": "HostFilter", "params": {"type": "agent"}}, {"type": "GracePeriodFilter", "params": {"seconds": 86400}}],
+"dryRun": true, "maxRetire": 10, "maxRetirePercent": 5.5}],
+"protect": {"ids": ["abcdefg"], "roles": ["prod:db"]}}`,
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "mkk")
+	if err != nil {
+		t.Fatalf("error occurred while creating a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	want := &job{
+		name:    "web",
+		param:   &mackerel.FindHostsParam{Service: "prod", Roles: []string{"web"}},
+		filters: []mkk.Filter{&mkk.HostFilter{Type: "agent"}, &mkk.GracePeriodFilter{Seconds: 86400}},
+		dryRun:  true,
+		limit:   mkk.RetireLimit{Max: 10, MaxPercent: 5.5},
+	}
+
+	wantProtect := &mkk.Protection{IDs: []string{"abcdefg"}, Roles: []string{"prod:db"}}
+
+	for i, tc := range cases {
+		t.Run(tc.title, func(t *testing.T) {
+			path := filepath.Join(dir, tc.file)
+			if err := ioutil.WriteFile(path, []byte(tc.content), 0644); err != nil {
+				t.Fatalf("#%d error occurred while writing a config file: %v", i, err)
+			}
+
+			conf, err := loadConfig(path)
+			if err != nil {
+				t.Fatalf("#%d loadConfig returned error: %v", i, err)
+			}
+
+			if got, want := conf.Protect, wantProtect; !reflect.DeepEqual(got, want) {
+				t.Errorf("#%d invalid protect: got: %+v, want: %+v", i, got, want)
+			}
+
+			jc := conf.findJob("web")
+			if jc == nil {
+				t.Fatalf("#%d job named web is not found", i)
+			}
+
+			j, err := jc.build()
+			if err != nil {
+				t.Fatalf("#%d jobConfig.build returned error: %v", i, err)
+			}
+
+			if got := j; !reflect.DeepEqual(got, want) {
+				t.Errorf("#%d invalid job: got: %+v, want: %+v", i, got, want)
+			}
+		})
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	filters := []mkk.FilterSpec{{Type: "HostFilter"}}
+
+	cases := []struct {
+		title string
+		conf  config
+		error bool
+	}{
+		{
+			title: "Valid jobs",
+			conf:  config{Jobs: []*jobConfig{{Name: "a", Filters: filters}, {Name: "b", Filters: filters}}},
+		},
+		{
+			title: "Missing name",
+			conf:  config{Jobs: []*jobConfig{{Filters: filters}}},
+			error: true,
+		},
+		{
+			title: "Duplicated names",
+			conf:  config{Jobs: []*jobConfig{{Name: "a", Filters: filters}, {Name: "a", Filters: filters}}},
+			error: true,
+		},
+		{
+			title: "Missing filters",
+			conf:  config{Jobs: []*jobConfig{{Name: "a"}}},
+			error: true,
+		},
+		{
+			title: "Invalid protect",
+			conf:  config{Jobs: []*jobConfig{{Name: "a", Filters: filters}}, Protect: &mkk.Protection{Names: []string{"("}}},
+			error: true,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(tc.title, func(t *testing.T) {
+			err := tc.conf.validate()
+			if got, want := err != nil, tc.error; got != want {
+				t.Errorf("#%d invalid result of validate: got: %v", i, err)
+			}
+		})
+	}
+}
+
+func TestCLI_RunConfig_Now(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mkk")
+	if err != nil {
+		t.Fatalf("error occurred while creating a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := `{"jobs": [{"name": "web", "filters": [{"type": "GracePeriodFilter", "params": {"seconds": 86400}}]}]}`
+	path := filepath.Join(dir, "mkk.json")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("error occurred while writing a config file: %v", err)
+	}
+
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	c := cli{outStream: outStream, errStream: errStream}
+
+	// The job is rejected before any request since it retires hosts
+	args := []string{"mkk", "run", "-t", "aqbc", "--config", path, "--now", "-7d", "web"}
+	if got, want := c.run(args), ExitCodeInvalidFlagError; got != want {
+		t.Fatalf("invalid exit code: got: %v, want: %v: %s", got, want, errStream.String())
+	}
+
+	if got, want := errStream.String(), "--now requires --dry-run, but job `web` retires hosts"; !strings.Contains(got, want) {
+		t.Errorf("invalid errStream: got: %q, want: %q", got, want)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/shuheiktgw/mackerel-killer/pkg/mkk"
+)
+
+var explainHost string
+
+// runExplain prints the verdict of every filter on a host
+func (c *cli) runExplain(ctx context.Context, args []string) int {
+	flags := c.newFlagSet(Name + " explain")
+
+	flags.StringVar(&explainHost, "host", "", "")
+
+	flags.StringVar(&filters, "filters", "", "")
+	flags.StringVar(&filters, "F", "", "")
+
+	addSnapshotFlags(flags)
+
+	if err := flags.Parse(args[1:]); err != nil {
+		c.printErrorf("Error occurred while parsing flags: %s", err)
+		return ExitCodeParseFlagError
+	}
+
+	c.setupOutput()
+
+	if err := validateCommonFlags(); err != nil {
+		c.printErrorf("Flag validation fails: %s", err)
+		return ExitCodeInvalidFlagError
+	}
+
+	if len(explainHost) == 0 {
+		c.printErrorf("Flag validation fails: missing host ID\nPlease set it via `--host` option\n")
+		return ExitCodeInvalidFlagError
+	}
+
+	if len(filters) == 0 {
+		c.printErrorf("Flag validation fails: missing filters\nPlease set it via `-F` option\n")
+		return ExitCodeInvalidFlagError
+	}
+
+	fs, err := parseFilters(filters)
+	if err != nil {
+		c.printErrorf("Error occurred while parsing filters: %s\n", err)
+		return ExitCodeInvalidFlagError
+	}
+
+	client, err := c.newClient(ctx)
+	if err != nil {
+		c.printErrorf("Error occurred while reading a snapshot: %s", err)
+		return ExitCodeError
+	}
+	defer c.printRetries(client)
+
+	host, err := client.Client.FindHost(explainHost)
+	if err != nil {
+		c.printErrorf("Error occurred while finding a host: %s", err)
+		return ExitCodeError
+	}
+
+	t, err := client.ExplainContext(ctx, host, fs)
+	if err != nil {
+		c.printErrorf("Error occurred while explaining filters: %s", err)
+		return ExitCodeError
+	}
+
+	if err := writeTrace(c.outStream, t, output); err != nil {
+		c.printErrorf("Error occurred while writing a trace: %s", err)
+		return ExitCodeError
+	}
+
+	return ExitCodeOK
+}
+
+// writeTrace writes the trace in JSON for json and jsonl output formats, otherwise in text
+func writeTrace(w io.Writer, t *mkk.Trace, format string) error {
+	if format == OutputJSON || format == OutputJSONL {
+		return json.NewEncoder(w).Encode(t)
+	}
+
+	result := "not selected"
+	if t.Selected {
+		result = "selected"
+	}
+
+	if _, err := fmt.Fprintf(w, "id: %v, name: %v: %s\n", t.Host.ID, t.Host.Name, result); err != nil {
+		return err
+	}
+
+	for _, v := range t.Verdicts {
+		verdict := "drop"
+		if v.Selected {
+			verdict = "keep"
+		}
+
+		if _, err := fmt.Fprintf(w, "    [%s] %s: %s\n", verdict, v.Filter, v.Reason); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/shuheiktgw/mackerel-killer/pkg/mkk"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+func TestWriteTrace(t *testing.T) {
+	trace := &mkk.Trace{
+		Host: &mackerel.Host{ID: "abcdefg", Name: "web-1"},
+		Verdicts: []*mkk.Verdict{
+			{Filter: "HostFilter{Type:unknown}", Selected: true, Reason: `type is "unknown"`},
+			{Filter: "GracePeriodFilter{Seconds:86400}", Selected: false, Reason: "created 3h0m0s ago, inside 86400s grace period"},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := writeTrace(&buf, trace, OutputText); err != nil {
+		t.Fatalf("writeTrace returned error: %v", err)
+	}
+
+	want := "id: abcdefg, name: web-1: not selected\n" +
+		"    [keep] HostFilter{Type:unknown}: type is \"unknown\"\n" +
+		"    [drop] GracePeriodFilter{Seconds:86400}: created 3h0m0s ago, inside 86400s grace period\n"
+
+	if got := buf.String(); got != want {
+		t.Errorf("invalid output: got: %q, want: %q", got, want)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+
+	"github.com/shuheiktgw/mackerel-killer/pkg/mkk"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+// job finds hosts with param and filters and retires them unless dryRun
+type job struct {
+	name    string
+	param   *mackerel.FindHostsParam
+	filters []mkk.Filter
+	dryRun  bool
+	limit   mkk.RetireLimit
+
+	continueOnError bool
+}
+
+// newJobFromFlags builds job from the hosts and filters flags
+func (c *cli) newJobFromFlags() (*job, int) {
+	c.printDebugf("Raw hosts flag: %v", hosts)
+
+	param, err := parseHosts(hosts)
+	if err != nil {
+		c.printErrorf("Error occurred while parsing hosts query parameters: %s\n", err)
+		return nil, ExitCodeInvalidFlagError
+	}
+
+	c.printDebugf("Parsed mackerel.FindHostsParam: %v", param)
+	c.printDebugf("Raw filters flag: %v", filters)
+
+	fs, err := parseFilters(filters)
+	if err != nil {
+		c.printErrorf("Error occurred while parsing filters: %s\n", err)
+		return nil, ExitCodeInvalidFlagError
+	}
+
+	if c.debug {
+		for i, f := range fs {
+			c.printDebugf("Parsed filter #%d: %s", i, mkk.DescribeFilter(f))
+		}
+	}
+
+	return &job{
+		param:   param,
+		filters: fs,
+		dryRun:  dryRun,
+		limit:   mkk.RetireLimit{Max: maxRetire, MaxPercent: maxRetirePercent},
+
+		continueOnError: continueOnError,
+	}, ExitCodeOK
+}
+
+// runJob retires the hosts selected by the job, or just prints them in dry run mode
+// Hosts are written to w, which is shared by all the jobs and flushed by the caller
+func (c *cli) runJob(ctx context.Context, client *mkk.Mkk, j *job, w recordWriter) int {
+	ts, code := c.selectHosts(ctx, client, j)
+	if code != ExitCodeOK || len(ts) == 0 {
+		return code
+	}
+
+	// Hosts are never retired as of another time given by --now
+	if j.dryRun || client.Clock != nil {
+		c.printInfof("Running in Dry Run mode")
+		c.printInfof("Hosts below will be retired without --dry-run flag\n")
+
+		for _, t := range ts {
+			r := newHostRecord(j.name, t.Host, ResultSelected, nil)
+			err := client.CheckContext(ctx, t.Host)
+			if ctx.Err() != nil {
+				c.printInfof("Interrupted while checking hosts")
+				return ExitCodeInterrupted
+			}
+
+			if mkk.IsRefused(err) {
+				r = newHostRecord(j.name, t.Host, ResultSkipped, err)
+			} else if err != nil {
+				r = newHostRecord(j.name, t.Host, ResultFailed, err)
+			}
+			r.Reasons = t.Reasons()
+			w.Write(r)
+		}
+
+		return ExitCodeOK
+	}
+
+	return c.retire(ctx, client, j, hostsOf(ts), w)
+}
+
+// selectHosts finds hosts selected by the job and checks them against the safety caps
+// It returns the traces of the selected hosts
+func (c *cli) selectHosts(ctx context.Context, client *mkk.Mkk, j *job) ([]*mkk.Trace, int) {
+	c.printInfof("Finding hosts...")
+	found, err := client.Client.FindHosts(j.param)
+	if err != nil {
+		if ctx.Err() != nil {
+			c.printInfof("Interrupted before retiring any hosts")
+			return nil, ExitCodeInterrupted
+		}
+
+		c.printErrorf("Error occurred while finding hosts: %s\n", err)
+		return nil, ExitCodeError
+	}
+
+	c.printDebugf("%d hosts found before filtering", len(found))
+
+	traces, err := client.EvaluateContext(ctx, found, j.filters)
+	if err != nil {
+		if ctx.Err() != nil {
+			c.printInfof("Interrupted before retiring any hosts")
+			return nil, ExitCodeInterrupted
+		}
+
+		c.printErrorf("Error occurred while finding hosts: %s\n", err)
+		return nil, ExitCodeError
+	}
+
+	var ts []*mkk.Trace
+	for _, t := range traces {
+		if t.Selected {
+			ts = append(ts, t)
+			continue
+		}
+
+		if c.debug {
+			last := t.Verdicts[len(t.Verdicts)-1]
+			c.printDebugf("Dropped host: id: %v, name: %v, by %s: %s", t.Host.ID, t.Host.Name, last.Filter, last.Reason)
+		}
+	}
+
+	if len(ts) > 0 {
+		c.printInfof("%d hosts found", len(ts))
+
+		if c.debug {
+			for i, t := range ts {
+				c.printDebugf("Found host #%d: %v", i, t.Host)
+			}
+		}
+	} else {
+		c.printInfof("No hosts found with the specified query parameters and filters")
+		return nil, ExitCodeOK
+	}
+
+	if err := j.limit.Check(len(ts), len(found)); err != nil {
+		c.printErrorf("Aborted without retiring any hosts: %s", err)
+		return nil, ExitCodeLimitExceeded
+	}
+
+	return ts, ExitCodeOK
+}
+
+func hostsOf(ts []*mkk.Trace) []*mackerel.Host {
+	hs := make([]*mackerel.Host, 0, len(ts))
+	for _, t := range ts {
+		hs = append(hs, t.Host)
+	}
+
+	return hs
+}
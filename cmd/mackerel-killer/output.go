@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+// Output formats of the hosts selected or retired
+const (
+	OutputText  = "text"
+	OutputJSON  = "json"
+	OutputJSONL = "jsonl"
+	OutputCSV   = "csv"
+	OutputTable = "table"
+)
+
+// Results of the hosts
+const (
+	ResultSelected = "selected"
+	ResultRetired  = "retired"
+	ResultFailed   = "failed"
+	ResultSkipped  = "skipped"
+)
+
+var output string
+
+var outputFormats = []string{OutputText, OutputJSON, OutputJSONL, OutputCSV, OutputTable}
+
+// hostRecord is a host written to stdout along with its result
+type hostRecord struct {
+	Job       string   `json:"job,omitempty"`
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`
+	Status    string   `json:"status"`
+	Roles     []string `json:"roles"`
+	CreatedAt int32    `json:"createdAt"`
+	Result    string   `json:"result"`
+	Error     string   `json:"error,omitempty"`
+	Reasons   []string `json:"reasons,omitempty"`
+}
+
+func newHostRecord(job string, host *mackerel.Host, result string, err error) *hostRecord {
+	roles := append([]string{}, host.GetRoleFullnames()...)
+	sort.Strings(roles)
+
+	r := hostRecord{
+		Job:       job,
+		ID:        host.ID,
+		Name:      host.Name,
+		Type:      host.Type,
+		Status:    host.Status,
+		Roles:     roles,
+		CreatedAt: host.CreatedAt,
+		Result:    result,
+	}
+
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	return &r
+}
+
+// recordWriter writes hostRecord in one of the output formats
+// Flush has to be called after all the records are written
+type recordWriter interface {
+	Write(*hostRecord) error
+	Flush() error
+}
+
+// newRecordWriter returns recordWriter for the given format
+func newRecordWriter(w io.Writer, format string) (recordWriter, error) {
+	switch format {
+	case OutputText:
+		return &textWriter{w: w}, nil
+	case OutputJSON:
+		return &jsonWriter{w: w, records: []*hostRecord{}}, nil
+	case OutputJSONL:
+		return &jsonlWriter{enc: json.NewEncoder(w)}, nil
+	case OutputCSV:
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	case OutputTable:
+		return &tableWriter{w: tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)}, nil
+	default:
+		return nil, fmt.Errorf("output format `%s` is not supported, it must be one of %s", format, strings.Join(outputFormats, ", "))
+	}
+}
+
+type textWriter struct {
+	w io.Writer
+	n int
+}
+
+func (t *textWriter) Write(r *hostRecord) error {
+	var line string
+
+	switch r.Result {
+	case ResultSelected:
+		line = fmt.Sprintf("#%d id: %v, name: %v\n", t.n, r.ID, r.Name)
+	case ResultRetired:
+		line = fmt.Sprintf("#%d Retired: id: %v, name: %v\n", t.n, r.ID, r.Name)
+	case ResultSkipped:
+		line = fmt.Sprintf("#%d Skipped: id: %v, name: %v: %s\n", t.n, r.ID, r.Name, r.Error)
+	default:
+		line = fmt.Sprintf("#%d Failed: id: %v, name: %v: %s\n", t.n, r.ID, r.Name, r.Error)
+	}
+
+	for _, reason := range r.Reasons {
+		line += fmt.Sprintf("    %s\n", reason)
+	}
+
+	t.n++
+
+	_, err := io.WriteString(t.w, line)
+	return err
+}
+
+func (t *textWriter) Flush() error {
+	return nil
+}
+
+type jsonWriter struct {
+	w       io.Writer
+	records []*hostRecord
+}
+
+func (j *jsonWriter) Write(r *hostRecord) error {
+	j.records = append(j.records, r)
+	return nil
+}
+
+func (j *jsonWriter) Flush() error {
+	b, err := json.MarshalIndent(j.records, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(j.w, "%s\n", b)
+	return err
+}
+
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+func (j *jsonlWriter) Write(r *hostRecord) error {
+	return j.enc.Encode(r)
+}
+
+func (j *jsonlWriter) Flush() error {
+	return nil
+}
+
+var recordHeader = []string{"job", "id", "name", "type", "status", "roles", "createdAt", "result", "error"}
+
+var csvHeader = append(append([]string{}, recordHeader...), "reasons")
+
+type csvWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (c *csvWriter) Write(r *hostRecord) error {
+	if !c.header {
+		if err := c.w.Write(csvHeader); err != nil {
+			return err
+		}
+		c.header = true
+	}
+
+	return c.w.Write([]string{
+		r.Job, r.ID, r.Name, r.Type, r.Status, strings.Join(r.Roles, " "),
+		strconv.FormatInt(int64(r.CreatedAt), 10), r.Result, r.Error, strings.Join(r.Reasons, "; "),
+	})
+}
+
+func (c *csvWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type tableWriter struct {
+	w      *tabwriter.Writer
+	header bool
+}
+
+func (t *tableWriter) Write(r *hostRecord) error {
+	if !t.header {
+		if _, err := fmt.Fprintln(t.w, strings.ToUpper(strings.Join(recordHeader, "\t"))); err != nil {
+			return err
+		}
+		t.header = true
+	}
+
+	createdAt := time.Unix(int64(r.CreatedAt), 0).UTC().Format(time.RFC3339)
+
+	_, err := fmt.Fprintf(t.w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+		r.Job, r.ID, r.Name, r.Type, r.Status, strings.Join(r.Roles, ","), createdAt, r.Result, r.Error)
+	return err
+}
+
+func (t *tableWriter) Flush() error {
+	return t.w.Flush()
+}
+
+// newRecordWriter returns recordWriter writing to stdout in the format given by --output
+func (c *cli) newRecordWriter() recordWriter {
+	w, err := newRecordWriter(c.outStream, output)
+	if err != nil {
+		// output has been validated by validateCommonFlags
+		panic(err)
+	}
+
+	return w
+}
+
+func (c *cli) flushRecords(w recordWriter) {
+	if err := w.Flush(); err != nil {
+		c.printErrorf("Error occurred while writing hosts: %s", err)
+	}
+}
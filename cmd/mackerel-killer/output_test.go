@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+func TestRecordWriter(t *testing.T) {
+	host := &mackerel.Host{
+		ID:        "abcdefg",
+		Name:      "web-1",
+		Type:      "unknown",
+		Status:    "standby",
+		Roles:     mackerel.Roles{"prod": []string{"web"}},
+		CreatedAt: 1558910000,
+	}
+
+	records := []*hostRecord{
+		newHostRecord("", host, ResultRetired, nil),
+		newHostRecord("", host, ResultFailed, errors.New("API request failed")),
+	}
+
+	cases := []struct {
+		format string
+		want   string
+	}{
+		{
+			format: OutputText,
+			want: "#0 Retired: id: abcdefg, name: web-1\n" +
+				"#1 Failed: id: abcdefg, name: web-1: API request failed\n",
+		},
+		{
+			format: OutputJSONL,
+			want: `{"id":"abcdefg","name":"web-1","type":"unknown","status":"standby","roles":["prod:web"],"createdAt":1558910000,"result":"retired"}` + "\n" +
+				`{"id":"abcdefg","name":"web-1","type":"unknown","status":"standby","roles":["prod:web"],"createdAt":1558910000,"result":"failed","error":"API request failed"}` + "\n",
+		},
+		{
+			format: OutputCSV,
+			want: "job,id,name,type,status,roles,createdAt,result,error,reasons\n" +
+				",abcdefg,web-1,unknown,standby,prod:web,1558910000,retired,,\n" +
+				",abcdefg,web-1,unknown,standby,prod:web,1558910000,failed,API request failed,\n",
+		},
+		{
+			format: OutputTable,
+			want: "JOB  ID       NAME   TYPE     STATUS   ROLES     CREATEDAT             RESULT   ERROR\n" +
+				"     abcdefg  web-1  unknown  standby  prod:web  2019-05-26T22:33:20Z  retired  \n" +
+				"     abcdefg  web-1  unknown  standby  prod:web  2019-05-26T22:33:20Z  failed   API request failed\n",
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(tc.format, func(t *testing.T) {
+			var buf bytes.Buffer
+
+			w, err := newRecordWriter(&buf, tc.format)
+			if err != nil {
+				t.Fatalf("#%d newRecordWriter returned error: %v", i, err)
+			}
+
+			for _, r := range records {
+				if err := w.Write(r); err != nil {
+					t.Fatalf("#%d Write returned error: %v", i, err)
+				}
+			}
+
+			if err := w.Flush(); err != nil {
+				t.Fatalf("#%d Flush returned error: %v", i, err)
+			}
+
+			if got, want := buf.String(), tc.want; got != want {
+				t.Errorf("#%d invalid output: got: %q, want: %q", i, got, want)
+			}
+		})
+	}
+}
+
+func TestRecordWriter_JSON(t *testing.T) {
+	var buf bytes.Buffer
+
+	w, err := newRecordWriter(&buf, OutputJSON)
+	if err != nil {
+		t.Fatalf("newRecordWriter returned error: %v", err)
+	}
+
+	// An empty array is written even if there are no records
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+
+	if got, want := buf.String(), "[]\n"; got != want {
+		t.Errorf("invalid output: got: %q, want: %q", got, want)
+	}
+}
+
+func TestNewRecordWriter_Unsupported(t *testing.T) {
+	if _, err := newRecordWriter(&bytes.Buffer{}, "xml"); err == nil {
+		t.Errorf("newRecordWriter is supposed to return error for an unsupported format")
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"github.com/shuheiktgw/mackerel-killer/pkg/mkk"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+var planFile string
+
+// runPlan writes the hosts selected to retire to a plan file
+func (c *cli) runPlan(ctx context.Context, args []string) int {
+	flags := c.newFlagSet(Name + " plan")
+	addSelectionFlags(flags)
+
+	flags.StringVar(&planFile, "out", "", "")
+	flags.StringVar(&planFile, "o", "", "")
+
+	if err := flags.Parse(args[1:]); err != nil {
+		c.printErrorf("Error occurred while parsing flags: %s", err)
+		return ExitCodeParseFlagError
+	}
+
+	c.setupOutput()
+
+	if err := validateFlags(); err != nil {
+		c.printErrorf("Flag validation fails: %s", err)
+		return ExitCodeInvalidFlagError
+	}
+
+	j, code := c.newJobFromFlags()
+	if code != ExitCodeOK {
+		return code
+	}
+
+	client := c.newMkk(ctx)
+	defer c.printRetries(client)
+
+	ts, code := c.selectHosts(ctx, client, j)
+	if code != ExitCodeOK {
+		return code
+	}
+
+	b, err := json.MarshalIndent(mkk.NewPlan(ts), "", "  ")
+	if err != nil {
+		c.printErrorf("Error occurred while encoding a plan: %s", err)
+		return ExitCodeError
+	}
+
+	if len(planFile) == 0 {
+		fmt.Fprintf(c.outStream, "%s\n", b)
+		return ExitCodeOK
+	}
+
+	if err := ioutil.WriteFile(planFile, append(b, '\n'), 0644); err != nil {
+		c.printErrorf("Error occurred while writing a plan: %s", err)
+		return ExitCodeError
+	}
+
+	c.printInfof("Plan to retire %d hosts is written to %s", len(ts), planFile)
+	c.printInfof("Run `%s apply %s` to retire them", Name, planFile)
+
+	return ExitCodeOK
+}
+
+// runApply retires the hosts in a plan file after checking that they have not changed since the plan is made
+func (c *cli) runApply(ctx context.Context, args []string) int {
+	flags := c.newFlagSet(Name + " apply")
+
+	flags.BoolVar(&continueOnError, "continue-on-error", false, "")
+
+	if err := flags.Parse(args[1:]); err != nil {
+		c.printErrorf("Error occurred while parsing flags: %s", err)
+		return ExitCodeParseFlagError
+	}
+
+	c.setupOutput()
+
+	if err := validateCommonFlags(); err != nil {
+		c.printErrorf("Flag validation fails: %s", err)
+		return ExitCodeInvalidFlagError
+	}
+
+	if clock != nil {
+		c.printErrorf("Flag validation fails: --now cannot be used with apply command\n")
+		return ExitCodeInvalidFlagError
+	}
+
+	if flags.NArg() != 1 {
+		c.printErrorf("Flag validation fails: missing a plan file\nUsage: %s apply [options] plan.json\n", Name)
+		return ExitCodeInvalidFlagError
+	}
+
+	p, err := readPlan(flags.Arg(0))
+	if err != nil {
+		c.printErrorf("Error occurred while reading a plan: %s", err)
+		return ExitCodeError
+	}
+
+	if len(p.Hosts) == 0 {
+		c.printInfof("No hosts to retire in the plan")
+		return ExitCodeOK
+	}
+
+	client := c.newMkk(ctx)
+	defer c.printRetries(client)
+
+	hs, code := c.verify(ctx, client, p)
+	if code != ExitCodeOK {
+		return code
+	}
+
+	w := c.newRecordWriter()
+	defer c.flushRecords(w)
+
+	return c.retire(ctx, client, &job{continueOnError: continueOnError}, hs, w)
+}
+
+// verify verifies every host in the plan and returns the current hosts
+// Changed or deleted hosts mean the plan is out of date, while any other error aborts with ExitCodeError
+func (c *cli) verify(ctx context.Context, client *mkk.Mkk, p *mkk.Plan) ([]*mackerel.Host, int) {
+	c.printInfof("Verifying %d hosts in the plan...", len(p.Hosts))
+
+	var hs []*mackerel.Host
+	var mismatched bool
+	for _, ph := range p.Hosts {
+		if ctx.Err() != nil {
+			c.printInfof("Interrupted before retiring any hosts")
+			return nil, ExitCodeInterrupted
+		}
+
+		h, err := client.Verify(ph)
+		if err != nil {
+			if !isPlanMismatch(err) {
+				c.printErrorf("Aborted without retiring any hosts: error occurred while verifying the plan: %s", err)
+				return nil, ExitCodeError
+			}
+
+			c.printErrorf("%s", err)
+			mismatched = true
+			continue
+		}
+
+		hs = append(hs, h)
+	}
+
+	if mismatched {
+		c.printErrorf("Aborted without retiring any hosts: the plan is out of date, please make a new plan")
+		return nil, ExitCodePlanMismatch
+	}
+
+	return hs, ExitCodeOK
+}
+
+// isPlanMismatch reports whether the error means the planned host has changed or no longer exists
+func isPlanMismatch(err error) bool {
+	if _, ok := err.(*mkk.PlanMismatchError); ok {
+		return true
+	}
+
+	apiErr, ok := errors.Cause(err).(*mackerel.APIError)
+	return ok && apiErr.StatusCode == http.StatusNotFound
+}
+
+func readPlan(path string) (*mkk.Plan, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var p mkk.Plan
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/shuheiktgw/mackerel-killer/pkg/mkk"
+)
+
+var (
+	snapshotFile string
+	fromSnapshot string
+)
+
+// runSnapshot writes the hosts found by --hosts and the responses the filters read to a snapshot file
+func (c *cli) runSnapshot(ctx context.Context, args []string) int {
+	flags := c.newFlagSet(Name + " snapshot")
+	addSelectionFlags(flags)
+
+	flags.StringVar(&snapshotFile, "out", "", "")
+	flags.StringVar(&snapshotFile, "o", "", "")
+
+	if err := flags.Parse(args[1:]); err != nil {
+		c.printErrorf("Error occurred while parsing flags: %s", err)
+		return ExitCodeParseFlagError
+	}
+
+	c.setupOutput()
+
+	if err := validateFlags(); err != nil {
+		c.printErrorf("Flag validation fails: %s", err)
+		return ExitCodeInvalidFlagError
+	}
+
+	j, code := c.newJobFromFlags()
+	if code != ExitCodeOK {
+		return code
+	}
+
+	client := c.newMkk(ctx)
+	defer c.printRetries(client)
+
+	c.printInfof("Finding hosts...")
+	found, err := client.Client.FindHosts(j.param)
+	if err != nil {
+		c.printErrorf("Error occurred while finding hosts: %s\n", err)
+		return ExitCodeError
+	}
+
+	c.printInfof("Taking a snapshot of %d hosts...", len(found))
+	s, err := client.TakeSnapshot(ctx, found, j.filters)
+	if err != nil {
+		if ctx.Err() != nil {
+			c.printInfof("Interrupted before taking a snapshot")
+			return ExitCodeInterrupted
+		}
+
+		c.printErrorf("Error occurred while taking a snapshot: %s", err)
+		return ExitCodeError
+	}
+
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		c.printErrorf("Error occurred while encoding a snapshot: %s", err)
+		return ExitCodeError
+	}
+
+	if len(snapshotFile) == 0 {
+		fmt.Fprintf(c.outStream, "%s\n", b)
+		return ExitCodeOK
+	}
+
+	if err := ioutil.WriteFile(snapshotFile, append(b, '\n'), 0644); err != nil {
+		c.printErrorf("Error occurred while writing a snapshot: %s", err)
+		return ExitCodeError
+	}
+
+	c.printInfof("Snapshot of %d hosts and %d responses is written to %s", len(s.Hosts), len(s.Responses), snapshotFile)
+	c.printInfof("Run `%s --from-snapshot %s -F ...` to evaluate filters against it", Name, snapshotFile)
+
+	return ExitCodeOK
+}
+
+// addSnapshotFlags adds the flag to evaluate filters against a snapshot
+func addSnapshotFlags(flags *flag.FlagSet) {
+	flags.StringVar(&fromSnapshot, "from-snapshot", "", "")
+}
+
+// newClient initializes mkk.Mkk with the flags, or from the snapshot given by --from-snapshot
+func (c *cli) newClient(ctx context.Context) (*mkk.Mkk, error) {
+	if len(fromSnapshot) == 0 {
+		return c.newMkk(ctx), nil
+	}
+
+	s, err := readSnapshot(fromSnapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	client := mkk.NewMkkFromSnapshot(s)
+	client.AllowWorking = allowWorking
+	client.Protection = protection
+	client.MetadataNamespace = metadataNamespace
+	if clock != nil {
+		client.Clock = clock
+	}
+
+	c.printInfof("Evaluating filters against the snapshot of %d hosts taken at %s", len(s.Hosts), time.Unix(s.CreatedAt, 0).UTC().Format(time.RFC3339))
+
+	return client, nil
+}
+
+func readSnapshot(path string) (*mkk.Snapshot, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var s mkk.Snapshot
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
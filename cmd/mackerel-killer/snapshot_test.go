@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCLI_Run_FromSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mkk")
+	if err != nil {
+		t.Fatalf("error occurred while creating a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	snapshot := `{
+  "createdAt": 1558915200,
+  "hosts": [
+    {"id": "a", "name": "web-1", "type": "agent", "status": "standby", "createdAt": 1558000000},
+    {"id": "b", "name": "web-2", "type": "agent", "status": "standby", "createdAt": 1558000000}
+  ],
+  "responses": [
+    {"method": "GET", "url": "/api/v0/hosts/a/metadata/mackerel-killer", "statusCode": 404, "body": {"error": {"message": "Metadata not found"}}},
+    {"method": "GET", "url": "/api/v0/hosts/a/metrics?from=1558911600&name=loadavg5&to=1558915200", "statusCode": 200, "body": {"metrics": []}},
+    {"method": "GET", "url": "/api/v0/hosts/b/metrics?from=1558911600&name=loadavg5&to=1558915200", "statusCode": 200, "body": {"metrics": [{"time": 1558915000, "value": 0.1}]}}
+  ]
+}`
+
+	path := filepath.Join(dir, "hosts.json")
+	if err := ioutil.WriteFile(path, []byte(snapshot), 0644); err != nil {
+		t.Fatalf("error occurred while writing a snapshot: %v", err)
+	}
+
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	c := cli{outStream: outStream, errStream: errStream}
+
+	args := []string{"mkk", "-t", "", "--from-snapshot", path, "-F", `[{"type":"MetricAbsenceFilter","params":{"name":"loadavg5","from":"-1h"}}]`}
+	if got, want := c.run(args), ExitCodeOK; got != want {
+		t.Fatalf("invalid exit code: got: %v, want: %v: %s", got, want, errStream.String())
+	}
+
+	if got, want := outStream.String(), "#0 id: a, name: web-1\n"; !strings.HasPrefix(got, want) {
+		t.Errorf("invalid outStream: got: %q, want: %q", got, want)
+	}
+
+	if got, want := errStream.String(), "Evaluating filters against the snapshot of 2 hosts taken at 2019-05-27T00:00:00Z"; !strings.Contains(got, want) {
+		t.Errorf("invalid errStream: got: %q, want: %q", got, want)
+	}
+}
+
+func TestCLI_Run_FromSnapshot_NoHostsJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mkk")
+	if err != nil {
+		t.Fatalf("error occurred while creating a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "hosts.json")
+	if err := ioutil.WriteFile(path, []byte(`{"createdAt": 1558915200, "hosts": [], "responses": []}`), 0644); err != nil {
+		t.Fatalf("error occurred while writing a snapshot: %v", err)
+	}
+
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	c := cli{outStream: outStream, errStream: errStream}
+
+	args := []string{"mkk", "-t", "", "--from-snapshot", path, "--output", "json", "-F", `[{"type":"GracePeriodFilter","params":{"seconds":3600}}]`}
+	if got, want := c.run(args), ExitCodeOK; got != want {
+		t.Fatalf("invalid exit code: got: %v, want: %v: %s", got, want, errStream.String())
+	}
+
+	// An empty array is written even if no hosts are selected
+	if got, want := outStream.String(), "[]\n"; got != want {
+		t.Errorf("invalid outStream: got: %q, want: %q", got, want)
+	}
+}
@@ -10,4 +10,5 @@ require (
 	github.com/pkg/errors v0.8.1
 	github.com/tcnksm/go-latest v0.0.0-20170313132115-e3007ae9052e
 	golang.org/x/net v0.0.0-20190522155817-f3200d17e092 // indirect
+	gopkg.in/yaml.v2 v2.2.2
 )
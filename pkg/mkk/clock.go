@@ -0,0 +1,59 @@
+package mkk
+
+import (
+	"context"
+	"time"
+)
+
+// Clock tells filters the current time
+// Filters evaluated by Mkk receive Mkk.Clock through the context
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc is a function used as Clock
+type ClockFunc func() time.Time
+
+// Now returns the time returned by the function
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// SystemClock is Clock which tells the current time of the system
+var SystemClock Clock = ClockFunc(time.Now)
+
+// FixedClock returns Clock which always tells the given time
+func FixedClock(t time.Time) Clock {
+	return ClockFunc(func() time.Time { return t })
+}
+
+type clockKey struct{}
+
+// WithClock returns a copy of the context which carries the clock to filters
+func WithClock(ctx context.Context, c Clock) context.Context {
+	return context.WithValue(ctx, clockKey{}, c)
+}
+
+// currentTime returns the current time of the clock in the context,
+// or the one of SystemClock when the context does not carry a clock
+func currentTime(ctx context.Context) time.Time {
+	if c, ok := ctx.Value(clockKey{}).(Clock); ok && c != nil {
+		return c.Now()
+	}
+
+	return SystemClock.Now()
+}
+
+// withClock returns the context carrying Mkk.Clock unless it is nil
+func (m *Mkk) withClock(ctx context.Context) context.Context {
+	if m.Clock == nil {
+		return ctx
+	}
+
+	return WithClock(ctx, m.Clock)
+}
+
+// filterContext returns the context carrying Mkk.Clock and Mkk.RateLimiter to filters
+func (m *Mkk) filterContext(ctx context.Context) context.Context {
+	return m.withRateLimiter(m.withClock(ctx))
+}
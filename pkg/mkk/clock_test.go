@@ -0,0 +1,35 @@
+package mkk
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+func TestMkk_Clock(t *testing.T) {
+	now := time.Date(2019, 5, 27, 0, 0, 0, 0, time.UTC)
+
+	m := NewMkk("")
+	m.Clock = FixedClock(now)
+
+	host := &mackerel.Host{ID: "a", CreatedAt: int32(now.Add(-72 * time.Hour).Unix())}
+
+	// The clock reaches the filters nested in combinators
+	filters := []Filter{&Not{Filter: &GracePeriodFilter{Period: Duration(96 * time.Hour)}}}
+
+	traces, err := m.Evaluate([]*mackerel.Host{host}, filters)
+	if err != nil {
+		t.Fatalf("Mkk.Evaluate returned error: %v", err)
+	}
+
+	want := []string{"Not(GracePeriodFilter{Period:96h}): created 72h0m0s ago, inside 96h grace period"}
+	if got := traces[0].Reasons(); !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid reasons: got: %v, want: %v", got, want)
+	}
+
+	if !traces[0].Selected {
+		t.Errorf("host is supposed to be selected")
+	}
+}
@@ -0,0 +1,203 @@
+package mkk
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+// AllOf selects the hosts which are selected by all of the given filters
+// AllOf is useful to nest conjunctions within AnyOf or Not
+type AllOf struct {
+	Filters []Filter
+}
+
+// AnyOf selects the hosts which are selected by at least one of the given filters
+type AnyOf struct {
+	Filters []Filter
+}
+
+// Not selects the hosts which are not selected by the given filter
+type Not struct {
+	Filter Filter
+}
+
+// Apply applies AllOf to the given hosts
+func (f *AllOf) Apply(m *mackerel.Client, hosts []*mackerel.Host) ([]*mackerel.Host, error) {
+	return applyExplainer(context.Background(), f, m, hosts)
+}
+
+// ApplyContext applies AllOf to the given hosts with the context
+func (f *AllOf) ApplyContext(ctx context.Context, m *mackerel.Client, hosts []*mackerel.Host) ([]*mackerel.Host, error) {
+	return applyExplainer(ctx, f, m, hosts)
+}
+
+// Explain explains AllOf on the given hosts
+// A host dropped by a filter is not passed to the following filters
+func (f *AllOf) Explain(ctx context.Context, m *mackerel.Client, hosts []*mackerel.Host) ([]*Verdict, error) {
+	verdicts := make([]*Verdict, len(hosts))
+	reasons := make([][]string, len(hosts))
+	rest := indexes(hosts)
+
+	for _, child := range f.Filters {
+		if len(rest) == 0 {
+			break
+		}
+
+		vs, err := explain(ctx, child, m, pick(hosts, rest))
+		if err != nil {
+			return nil, err
+		}
+
+		var selected []int
+		for j, i := range rest {
+			if vs[j].Selected {
+				reasons[i] = append(reasons[i], vs[j].Reason)
+				selected = append(selected, i)
+			} else {
+				verdicts[i] = newVerdict(false, "%s: %s", vs[j].Filter, vs[j].Reason)
+			}
+		}
+
+		rest = selected
+	}
+
+	for _, i := range rest {
+		verdicts[i] = newVerdict(true, "%s", strings.Join(reasons[i], "; "))
+	}
+
+	return verdicts, nil
+}
+
+// Apply applies AnyOf to the given hosts
+func (f *AnyOf) Apply(m *mackerel.Client, hosts []*mackerel.Host) ([]*mackerel.Host, error) {
+	return applyExplainer(context.Background(), f, m, hosts)
+}
+
+// ApplyContext applies AnyOf to the given hosts with the context
+func (f *AnyOf) ApplyContext(ctx context.Context, m *mackerel.Client, hosts []*mackerel.Host) ([]*mackerel.Host, error) {
+	return applyExplainer(ctx, f, m, hosts)
+}
+
+// Explain explains AnyOf on the given hosts
+// Each filter only receives the hosts which have not been selected by the preceding filters
+func (f *AnyOf) Explain(ctx context.Context, m *mackerel.Client, hosts []*mackerel.Host) ([]*Verdict, error) {
+	verdicts := make([]*Verdict, len(hosts))
+	reasons := make([][]string, len(hosts))
+	rest := indexes(hosts)
+
+	for _, child := range f.Filters {
+		if len(rest) == 0 {
+			break
+		}
+
+		vs, err := explain(ctx, child, m, pick(hosts, rest))
+		if err != nil {
+			return nil, err
+		}
+
+		var dropped []int
+		for j, i := range rest {
+			if vs[j].Selected {
+				verdicts[i] = newVerdict(true, "%s: %s", vs[j].Filter, vs[j].Reason)
+			} else {
+				reasons[i] = append(reasons[i], vs[j].Reason)
+				dropped = append(dropped, i)
+			}
+		}
+
+		rest = dropped
+	}
+
+	for _, i := range rest {
+		verdicts[i] = newVerdict(false, "%s", strings.Join(reasons[i], "; "))
+	}
+
+	return verdicts, nil
+}
+
+// Validate validates that Not has the child filter
+func (f *Not) Validate() error {
+	if f.Filter == nil {
+		return errors.New("Not: missing filter")
+	}
+
+	return nil
+}
+
+// Apply applies Not to the given hosts
+func (f *Not) Apply(m *mackerel.Client, hosts []*mackerel.Host) ([]*mackerel.Host, error) {
+	return applyExplainer(context.Background(), f, m, hosts)
+}
+
+// ApplyContext applies Not to the given hosts with the context
+func (f *Not) ApplyContext(ctx context.Context, m *mackerel.Client, hosts []*mackerel.Host) ([]*mackerel.Host, error) {
+	return applyExplainer(ctx, f, m, hosts)
+}
+
+// Explain explains Not on the given hosts
+func (f *Not) Explain(ctx context.Context, m *mackerel.Client, hosts []*mackerel.Host) ([]*Verdict, error) {
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+
+	vs, err := explain(ctx, f.Filter, m, hosts)
+	if err != nil {
+		return nil, err
+	}
+
+	verdicts := make([]*Verdict, 0, len(hosts))
+	for _, v := range vs {
+		verdicts = append(verdicts, newVerdict(!v.Selected, "%s", v.Reason))
+	}
+
+	return verdicts, nil
+}
+
+// indexes returns the indexes of the hosts
+func indexes(hosts []*mackerel.Host) []int {
+	is := make([]int, len(hosts))
+	for i := range hosts {
+		is[i] = i
+	}
+
+	return is
+}
+
+// pick returns the hosts at the given indexes
+func pick(hosts []*mackerel.Host, is []int) []*mackerel.Host {
+	hs := make([]*mackerel.Host, 0, len(is))
+	for _, i := range is {
+		hs = append(hs, hosts[i])
+	}
+
+	return hs
+}
+
+// String describes AllOf with its child filters
+func (f *AllOf) String() string {
+	return fmt.Sprintf("AllOf(%s)", describeFilters(f.Filters))
+}
+
+// String describes AnyOf with its child filters
+func (f *AnyOf) String() string {
+	return fmt.Sprintf("AnyOf(%s)", describeFilters(f.Filters))
+}
+
+// String describes Not with its child filter
+func (f *Not) String() string {
+	return fmt.Sprintf("Not(%s)", DescribeFilter(f.Filter))
+}
+
+func describeFilters(filters []Filter) string {
+	ds := make([]string, 0, len(filters))
+	for _, f := range filters {
+		ds = append(ds, DescribeFilter(f))
+	}
+
+	return strings.Join(ds, ", ")
+}
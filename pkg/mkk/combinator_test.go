@@ -0,0 +1,71 @@
+package mkk
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+func TestCombinators_Apply(t *testing.T) {
+	agent := &mackerel.Host{ID: "agent", Type: "agent"}
+	cloud := &mackerel.Host{ID: "cloud", Type: "cloud"}
+	unknown := &mackerel.Host{ID: "unknown", Type: "unknown"}
+	hosts := []*mackerel.Host{agent, cloud, unknown}
+
+	var cases = []struct {
+		title  string
+		filter Filter
+		want   []*mackerel.Host
+	}{
+		{
+			title:  "AllOf selects hosts matching all filters",
+			filter: &AllOf{Filters: []Filter{&HostFilter{Type: "agent"}, &HostFilter{Type: "cloud"}}},
+			want:   nil,
+		},
+		{
+			title:  "AllOf without filters selects all hosts",
+			filter: &AllOf{},
+			want:   hosts,
+		},
+		{
+			title:  "AnyOf keeps the original order",
+			filter: &AnyOf{Filters: []Filter{&HostFilter{Type: "unknown"}, &HostFilter{Type: "agent"}}},
+			want:   []*mackerel.Host{agent, unknown},
+		},
+		{
+			title:  "Not selects hosts not matching the filter",
+			filter: &Not{Filter: &HostFilter{Type: "cloud"}},
+			want:   []*mackerel.Host{agent, unknown},
+		},
+		{
+			title: "Nested combinators",
+			filter: &AllOf{Filters: []Filter{
+				&AnyOf{Filters: []Filter{&HostFilter{Type: "cloud"}, &HostFilter{Type: "unknown"}}},
+				&Not{Filter: &HostFilter{Type: "unknown"}},
+			}},
+			want: []*mackerel.Host{cloud},
+		},
+	}
+
+	for i, tc := range cases {
+		client := mackerel.Client{}
+
+		t.Run(tc.title, func(t *testing.T) {
+			filtered, err := tc.filter.Apply(&client, hosts)
+			if err != nil {
+				t.Fatalf("#%d Apply returned error: %v", i, err)
+			}
+
+			if got, want := filtered, tc.want; !reflect.DeepEqual(got, want) {
+				t.Errorf("#%d invalid hosts: got: %v, want: %v", i, got, want)
+			}
+		})
+	}
+}
+
+func TestNot_Apply_NilFilter(t *testing.T) {
+	if _, err := (&Not{}).Apply(&mackerel.Client{}, []*mackerel.Host{{ID: "a"}}); err == nil {
+		t.Errorf("Not.Apply is supposed to return error for a nil filter")
+	}
+}
@@ -0,0 +1,179 @@
+package mkk
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+// Verdict is a decision of a filter on a host along with the reason
+type Verdict struct {
+	Filter   string `json:"filter"`
+	Selected bool   `json:"selected"`
+	Reason   string `json:"reason"`
+}
+
+// Explainer is a Filter which explains why it keeps or drops each host
+type Explainer interface {
+	Filter
+
+	// Explain returns the verdicts on the given hosts in the same order
+	Explain(context.Context, *mackerel.Client, []*mackerel.Host) ([]*Verdict, error)
+}
+
+// Trace is the verdicts of the filters on a host
+// Selected is true when all the filters select the host
+type Trace struct {
+	Host     *mackerel.Host `json:"host"`
+	Verdicts []*Verdict     `json:"verdicts"`
+	Selected bool           `json:"selected"`
+}
+
+// Reasons returns the reasons of the verdicts prefixed by the filters
+func (t *Trace) Reasons() []string {
+	reasons := make([]string, 0, len(t.Verdicts))
+	for _, v := range t.Verdicts {
+		reasons = append(reasons, fmt.Sprintf("%s: %s", v.Filter, v.Reason))
+	}
+
+	return reasons
+}
+
+// Evaluate applies the given filters to hosts in order and records the verdicts on each host
+// Like Filter, a host dropped by a filter is not passed to the following filters
+// so its trace ends with the verdict of the filter which dropped it
+func (m *Mkk) Evaluate(hosts []*mackerel.Host, filters []Filter) ([]*Trace, error) {
+	return m.EvaluateContext(context.Background(), hosts, filters)
+}
+
+// EvaluateContext is Evaluate with the context
+func (m *Mkk) EvaluateContext(ctx context.Context, hosts []*mackerel.Host, filters []Filter) ([]*Trace, error) {
+	ctx = m.filterContext(ctx)
+
+	traces := make([]*Trace, 0, len(hosts))
+	for _, host := range hosts {
+		traces = append(traces, &Trace{Host: host, Selected: true})
+	}
+
+	rest := traces
+	for _, f := range filters {
+		if len(rest) == 0 {
+			break
+		}
+
+		hs := make([]*mackerel.Host, 0, len(rest))
+		for _, t := range rest {
+			hs = append(hs, t.Host)
+		}
+
+		verdicts, err := explain(ctx, f, m.Client, hs)
+		if err != nil {
+			return nil, errors.Wrap(err, "Mkk.Evaluate fails while applying filters")
+		}
+
+		var selected []*Trace
+		for i, t := range rest {
+			t.Verdicts = append(t.Verdicts, verdicts[i])
+
+			if verdicts[i].Selected {
+				selected = append(selected, t)
+			} else {
+				t.Selected = false
+			}
+		}
+
+		rest = selected
+	}
+
+	return traces, nil
+}
+
+// Explain evaluates every one of the given filters on the host
+// Unlike Evaluate, the filters following the one which dropped the host are evaluated as well
+func (m *Mkk) Explain(host *mackerel.Host, filters []Filter) (*Trace, error) {
+	return m.ExplainContext(context.Background(), host, filters)
+}
+
+// ExplainContext is Explain with the context
+func (m *Mkk) ExplainContext(ctx context.Context, host *mackerel.Host, filters []Filter) (*Trace, error) {
+	ctx = m.filterContext(ctx)
+
+	t := Trace{Host: host, Selected: true}
+
+	for _, f := range filters {
+		verdicts, err := explain(ctx, f, m.Client, []*mackerel.Host{host})
+		if err != nil {
+			return nil, errors.Wrap(err, "Mkk.Explain fails while applying filters")
+		}
+
+		t.Verdicts = append(t.Verdicts, verdicts[0])
+		t.Selected = t.Selected && verdicts[0].Selected
+	}
+
+	return &t, nil
+}
+
+// explain returns the verdicts of the filter on the hosts
+// Filters which do not implement Explainer are explained by the result of Apply
+func explain(ctx context.Context, f Filter, m *mackerel.Client, hosts []*mackerel.Host) ([]*Verdict, error) {
+	desc := DescribeFilter(f)
+
+	var verdicts []*Verdict
+
+	if e, ok := f.(Explainer); ok {
+		vs, err := e.Explain(ctx, m, hosts)
+		if err != nil {
+			return nil, err
+		}
+
+		verdicts = vs
+	} else {
+		hs, err := applyContext(ctx, f, m, hosts)
+		if err != nil {
+			return nil, err
+		}
+
+		selected := make(map[*mackerel.Host]bool)
+		for _, h := range hs {
+			selected[h] = true
+		}
+
+		for _, host := range hosts {
+			if selected[host] {
+				verdicts = append(verdicts, newVerdict(true, "selected by the filter"))
+			} else {
+				verdicts = append(verdicts, newVerdict(false, "dropped by the filter"))
+			}
+		}
+	}
+
+	for _, v := range verdicts {
+		v.Filter = desc
+	}
+
+	return verdicts, nil
+}
+
+// applyExplainer applies the filter by selecting the hosts with positive verdicts
+func applyExplainer(ctx context.Context, e Explainer, m *mackerel.Client, hosts []*mackerel.Host) ([]*mackerel.Host, error) {
+	verdicts, err := e.Explain(ctx, m, hosts)
+	if err != nil {
+		return nil, err
+	}
+
+	var filtered []*mackerel.Host
+	for i, host := range hosts {
+		if verdicts[i].Selected {
+			filtered = append(filtered, host)
+		}
+	}
+
+	return filtered, nil
+}
+
+func newVerdict(selected bool, format string, args ...interface{}) *Verdict {
+	return &Verdict{Selected: selected, Reason: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,82 @@
+package mkk
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+func TestMkk_Evaluate(t *testing.T) {
+	m := NewMkk("")
+
+	old := &mackerel.Host{ID: "old", Type: "unknown", CreatedAt: int32(time.Now().Unix() - 1000)}
+	young := &mackerel.Host{ID: "young", Type: "unknown", CreatedAt: int32(time.Now().Unix())}
+	agent := &mackerel.Host{ID: "agent", Type: "agent", CreatedAt: int32(time.Now().Unix() - 1000)}
+
+	filters := []Filter{&HostFilter{Type: "unknown"}, &GracePeriodFilter{Seconds: 100}}
+
+	traces, err := m.Evaluate([]*mackerel.Host{old, young, agent}, filters)
+	if err != nil {
+		t.Fatalf("Mkk.Evaluate returned error: %v", err)
+	}
+
+	var cases = []struct {
+		selected bool
+		verdicts []bool
+	}{
+		{selected: true, verdicts: []bool{true, true}},
+		{selected: false, verdicts: []bool{true, false}},
+		// The host dropped by HostFilter is not evaluated by GracePeriodFilter
+		{selected: false, verdicts: []bool{false}},
+	}
+
+	for i, tc := range cases {
+		if got, want := traces[i].Selected, tc.selected; got != want {
+			t.Errorf("#%d invalid result: got: %v, want: %v", i, got, want)
+		}
+
+		var verdicts []bool
+		for _, v := range traces[i].Verdicts {
+			verdicts = append(verdicts, v.Selected)
+		}
+
+		if got, want := verdicts, tc.verdicts; !reflect.DeepEqual(got, want) {
+			t.Errorf("#%d invalid verdicts: got: %v, want: %v", i, got, want)
+		}
+	}
+}
+
+func TestMkk_Explain(t *testing.T) {
+	m := NewMkk("")
+
+	host := &mackerel.Host{ID: "agent", Type: "agent", CreatedAt: int32(time.Now().Unix())}
+
+	filters := []Filter{
+		&HostFilter{Type: "unknown"},
+		&AnyOf{Filters: []Filter{&HostFilter{Type: "cloud"}, &Not{Filter: &HostFilter{Type: "unknown"}}}},
+	}
+
+	trace, err := m.Explain(host, filters)
+	if err != nil {
+		t.Fatalf("Mkk.Explain returned error: %v", err)
+	}
+
+	want := &Trace{
+		Host: host,
+		Verdicts: []*Verdict{
+			{Filter: "HostFilter{Type:unknown}", Selected: false, Reason: `type is "agent", not "unknown"`},
+			{
+				Filter:   "AnyOf(HostFilter{Type:cloud}, Not(HostFilter{Type:unknown}))",
+				Selected: true,
+				Reason:   `Not(HostFilter{Type:unknown}): type is "agent", not "unknown"`,
+			},
+		},
+		Selected: false,
+	}
+
+	if got := trace; !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid trace: got: %+v, want: %+v", got, want)
+	}
+}
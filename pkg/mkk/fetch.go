@@ -0,0 +1,114 @@
+package mkk
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+const (
+	// DefaultConcurrency is the default number of hosts whose metrics are fetched at the same time
+	DefaultConcurrency = 4
+
+	// DefaultRequestsPerSecond is the default upper limit of requests sent to Mackerel per second by filters
+	DefaultRequestsPerSecond = 10
+)
+
+// metricFetcher fetches metric values of hosts with a bounded number of workers
+// and token bucket rate limiters of the filter and the one shared through the context
+type metricFetcher struct {
+	concurrency int
+	limiter     *tokenBucket
+	shared      *RateLimiter
+}
+
+// newMetricFetcher initializes metricFetcher
+// A zero concurrency falls back to DefaultConcurrency, and a negative requestsPerSecond disables the limiter of the filter
+// When the context carries a shared RateLimiter, the filter is limited only by it unless requestsPerSecond is positive,
+// otherwise a zero requestsPerSecond falls back to DefaultRequestsPerSecond
+func newMetricFetcher(ctx context.Context, concurrency int, requestsPerSecond float64) *metricFetcher {
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+
+	shared := rateLimiter(ctx)
+	if requestsPerSecond == 0 && shared == nil {
+		requestsPerSecond = DefaultRequestsPerSecond
+	}
+
+	return &metricFetcher{
+		concurrency: concurrency,
+		limiter:     newTokenBucket(requestsPerSecond, concurrency),
+		shared:      shared,
+	}
+}
+
+// fetch fetches the values of the named metric between from and to for each host
+// The values are returned in the same order as hosts. It stops fetching at the first error
+// or when the context is done, after waiting for the in-flight requests
+func (f *metricFetcher) fetch(ctx context.Context, m *mackerel.Client, hosts []*mackerel.Host, name string, from, to int64) ([][]mackerel.MetricValue, error) {
+	results := make([][]mackerel.MetricValue, len(hosts))
+	errs := make([]error, len(hosts))
+
+	jobs := make(chan int)
+	done := make(chan struct{})
+	var once sync.Once
+	var wg sync.WaitGroup
+
+	for w := 0; w < f.concurrency && w < len(hosts); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range jobs {
+				if err := f.limiter.Wait(ctx); err != nil {
+					errs[i] = err
+					continue
+				}
+
+				if err := f.shared.Wait(ctx); err != nil {
+					errs[i] = err
+					continue
+				}
+
+				host := hosts[i]
+				values, err := m.FetchHostMetricValues(host.ID, name, from, to)
+				if err != nil {
+					errs[i] = errors.Wrapf(err, "error occurred while fetching a metric: host: id: %v, name: %v, metric: %v", host.ID, host.Name, name)
+					once.Do(func() { close(done) })
+					continue
+				}
+
+				results[i] = values
+			}
+		}()
+	}
+
+Loop:
+	for i := range hosts {
+		select {
+		case jobs <- i:
+		case <-done:
+			break Loop
+		case <-ctx.Done():
+			break Loop
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
@@ -1,6 +1,12 @@
 package mkk
 
 import (
+	"context"
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -13,10 +19,46 @@ type Filter interface {
 	Apply(*mackerel.Client, []*mackerel.Host) ([]*mackerel.Host, error)
 }
 
-// GracePeriodFilter sets a grace period in second
+// ContextFilter is a Filter which stops applying itself when the context is done
+type ContextFilter interface {
+	Filter
+	ApplyContext(context.Context, *mackerel.Client, []*mackerel.Host) ([]*mackerel.Host, error)
+}
+
+// applyContext applies the filter with the context
+// Filters which do not implement ContextFilter are applied unless the context is already done
+func applyContext(ctx context.Context, f Filter, m *mackerel.Client, hosts []*mackerel.Host) ([]*mackerel.Host, error) {
+	if cf, ok := f.(ContextFilter); ok {
+		return cf.ApplyContext(ctx, m, hosts)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return f.Apply(m, hosts)
+}
+
+// DescribeFilter returns a human readable description of the filter
+// such as HostFilter{Type:agent}, unless the filter implements fmt.Stringer
+func DescribeFilter(f Filter) string {
+	if s, ok := f.(fmt.Stringer); ok {
+		return s.String()
+	}
+
+	v := reflect.Indirect(reflect.ValueOf(f))
+	if v.Kind() != reflect.Struct {
+		return fmt.Sprintf("%v", f)
+	}
+
+	return fmt.Sprintf("%s%+v", v.Type().Name(), v.Interface())
+}
+
+// GracePeriodFilter sets a grace period either in Seconds or in Period such as "72h"
 // and filters out hosts which created within the period
 type GracePeriodFilter struct {
 	Seconds int64
+	Period  Duration
 }
 
 // HostFilter selects the hosts with specified attribute
@@ -27,60 +69,539 @@ type HostFilter struct {
 }
 
 // MetricAbsenceFilter selects hosts which does not report
-// the specified metric within the given time period
+// the specified metric within the given time period in epoch seconds, where To defaults to now
+// In JSON, from and to may also be relative to now such as "-24h" or ISO-8601 timestamps,
+// and the relative ones are kept in FromTime and ToTime
+// Metrics of up to Concurrency hosts are fetched at the same time
+// and requests are limited to RequestsPerSecond, which is disabled when negative,
+// along with Mkk.RateLimiter shared by all the filters
 type MetricAbsenceFilter struct {
 	Name string
 	From int64
 	To   int64
+
+	Concurrency       int
+	RequestsPerSecond float64
+
+	// FromTime and ToTime take precedence over From and To unless they are zero,
+	// which may be relative to now such as Relative(-24 * time.Hour)
+	FromTime Time `json:"-"`
+	ToTime   Time `json:"-"`
+}
+
+// DefaultLatestMetricBatchSize is the default number of hosts queried in a single request by LatestMetricStaleFilter
+const DefaultLatestMetricBatchSize = 100
+
+// LatestMetricStaleFilter selects hosts whose latest value of the specified metric
+// is missing or older than the given seconds
+// It queries the latest values of up to BatchSize hosts in a single request
+type LatestMetricStaleFilter struct {
+	Name      string
+	Seconds   int64
+	BatchSize int
+}
+
+// NameFilter selects hosts whose names match any of Include
+// and none of Exclude regular expressions
+// Fields are the host attributes to match, which are name, displayName and customIdentifier
+// All of them are matched when Fields is empty, and every host is included when Include is empty
+type NameFilter struct {
+	Include []string
+	Exclude []string
+	Fields  []string
+}
+
+// nameFields lists the host attributes NameFilter can match
+var nameFields = []string{"name", "displayName", "customIdentifier"}
+
+// RoleFilter selects hosts by their role fullnames such as service:role
+// Include and Exclude are glob patterns like prod:* or *:batch
+// A host is selected when any of its roles matches Include, or it has no roles and NoRoles is set,
+// and none of its roles matches Exclude
+// Every host is included when neither Include nor NoRoles is given
+type RoleFilter struct {
+	Include []string
+	Exclude []string
+	NoRoles bool
+}
+
+// StatusFilter selects hosts whose status is any of Statuses,
+// which are working, standby, maintenance and poweroff
+type StatusFilter struct {
+	Statuses []string
+}
+
+// hostStatuses lists the host statuses StatusFilter accepts
+var hostStatuses = []string{
+	mackerel.HostStatusWorking,
+	mackerel.HostStatusStandby,
+	mackerel.HostStatusMaintenance,
+	mackerel.HostStatusPoweroff,
+}
+
+// Validate validates that either Seconds or Period is given to GracePeriodFilter
+func (f *GracePeriodFilter) Validate() error {
+	if f.Seconds != 0 && f.Period != 0 {
+		return errors.New("GracePeriodFilter: either seconds or period can be given")
+	}
+
+	return nil
+}
+
+func (f *GracePeriodFilter) String() string {
+	if f.Period != 0 {
+		return fmt.Sprintf("GracePeriodFilter{Period:%v}", f.Period)
+	}
+
+	return fmt.Sprintf("GracePeriodFilter{Seconds:%d}", f.Seconds)
+}
+
+// period returns the grace period in seconds along with its description
+func (f *GracePeriodFilter) period() (int64, string) {
+	if f.Period != 0 {
+		return int64(time.Duration(f.Period) / time.Second), f.Period.String()
+	}
+
+	return f.Seconds, fmt.Sprintf("%ds", f.Seconds)
 }
 
 // Apply applies GracePeriodFilter to the given hosts
-func (f *GracePeriodFilter) Apply(_ *mackerel.Client, hosts []*mackerel.Host) ([]*mackerel.Host, error) {
-	var filtered []*mackerel.Host
+func (f *GracePeriodFilter) Apply(m *mackerel.Client, hosts []*mackerel.Host) ([]*mackerel.Host, error) {
+	return applyExplainer(context.Background(), f, m, hosts)
+}
+
+// ApplyContext applies GracePeriodFilter to the given hosts with the context
+func (f *GracePeriodFilter) ApplyContext(ctx context.Context, m *mackerel.Client, hosts []*mackerel.Host) ([]*mackerel.Host, error) {
+	return applyExplainer(ctx, f, m, hosts)
+}
+
+// Explain explains GracePeriodFilter on the given hosts
+func (f *GracePeriodFilter) Explain(ctx context.Context, _ *mackerel.Client, hosts []*mackerel.Host) ([]*Verdict, error) {
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
 
+	now := currentTime(ctx).Unix()
+	seconds, period := f.period()
+
+	verdicts := make([]*Verdict, 0, len(hosts))
 	for _, host := range hosts {
-		if int64(host.CreatedAt) < time.Now().Unix()-f.Seconds {
-			filtered = append(filtered, host)
+		age := time.Duration(now-int64(host.CreatedAt)) * time.Second
+
+		if int64(host.CreatedAt) < now-seconds {
+			verdicts = append(verdicts, newVerdict(true, "created %v ago, outside %s grace period", age, period))
+		} else {
+			verdicts = append(verdicts, newVerdict(false, "created %v ago, inside %s grace period", age, period))
 		}
 	}
 
-	return filtered, nil
+	return verdicts, nil
 }
 
 // Apply applies HostFilter to the given hosts
-func (f *HostFilter) Apply(_ *mackerel.Client, hosts []*mackerel.Host) ([]*mackerel.Host, error) {
-	var filtered []*mackerel.Host
+func (f *HostFilter) Apply(m *mackerel.Client, hosts []*mackerel.Host) ([]*mackerel.Host, error) {
+	return applyExplainer(context.Background(), f, m, hosts)
+}
 
+// ApplyContext applies HostFilter to the given hosts with the context
+func (f *HostFilter) ApplyContext(ctx context.Context, m *mackerel.Client, hosts []*mackerel.Host) ([]*mackerel.Host, error) {
+	return applyExplainer(ctx, f, m, hosts)
+}
+
+// Explain explains HostFilter on the given hosts
+func (f *HostFilter) Explain(_ context.Context, _ *mackerel.Client, hosts []*mackerel.Host) ([]*Verdict, error) {
+	verdicts := make([]*Verdict, 0, len(hosts))
 	for _, host := range hosts {
 		if host.Type == f.Type {
-			filtered = append(filtered, host)
+			verdicts = append(verdicts, newVerdict(true, "type is %q", host.Type))
+		} else {
+			verdicts = append(verdicts, newVerdict(false, "type is %q, not %q", host.Type, f.Type))
 		}
 	}
 
-	return filtered, nil
+	return verdicts, nil
+}
+
+// UnmarshalJSON decodes MetricAbsenceFilter whose from and to are given in any form accepted by Time
+func (f *MetricAbsenceFilter) UnmarshalJSON(b []byte) error {
+	// plain does not have UnmarshalJSON, and its From and To are shadowed by Time
+	type plain MetricAbsenceFilter
+	var v struct {
+		plain
+		From Time
+		To   Time
+	}
+
+	if err := decodeStrict(b, &v); err != nil {
+		return err
+	}
+
+	*f = MetricAbsenceFilter(v.plain)
+	f.From, f.FromTime = splitTime(v.From)
+	f.To, f.ToTime = splitTime(v.To)
+
+	return nil
+}
+
+// window returns From and To of MetricAbsenceFilter where FromTime and ToTime take precedence
+func (f *MetricAbsenceFilter) window() (Time, Time) {
+	return metricWindow(f.From, f.FromTime, f.To, f.ToTime)
+}
+
+func (f *MetricAbsenceFilter) String() string {
+	from, to := f.window()
+	return fmt.Sprintf("MetricAbsenceFilter{Name:%s From:%v To:%v Concurrency:%d RequestsPerSecond:%v}", f.Name, from, to, f.Concurrency, f.RequestsPerSecond)
 }
 
 // Apply applies MetricAbsenceFilter to the given hosts
 func (f *MetricAbsenceFilter) Apply(m *mackerel.Client, hosts []*mackerel.Host) ([]*mackerel.Host, error) {
-	if f.To == 0 {
-		f.To = time.Now().Unix()
+	return applyExplainer(context.Background(), f, m, hosts)
+}
+
+// ApplyContext applies MetricAbsenceFilter to the given hosts with the context
+func (f *MetricAbsenceFilter) ApplyContext(ctx context.Context, m *mackerel.Client, hosts []*mackerel.Host) ([]*mackerel.Host, error) {
+	return applyExplainer(ctx, f, m, hosts)
+}
+
+// Explain explains MetricAbsenceFilter on the given hosts
+func (f *MetricAbsenceFilter) Explain(ctx context.Context, m *mackerel.Client, hosts []*mackerel.Host) ([]*Verdict, error) {
+	fromTime, toTime := f.window()
+	from, to := resolveWindow(fromTime, toTime, currentTime(ctx))
+
+	results, err := newMetricFetcher(ctx, f.Concurrency, f.RequestsPerSecond).fetch(ctx, m, hosts, f.Name, from, to)
+	if err != nil {
+		return nil, errors.Wrap(err, "MetricAbsenceFilter.Explain fails while applying a filter")
 	}
 
-	var filtered []*mackerel.Host
+	window := fmt.Sprintf("between %s and %s", formatUnix(from), formatUnix(to))
 
-	for _, host := range hosts {
-		time.Sleep(2 * time.Millisecond)
+	verdicts := make([]*Verdict, 0, len(hosts))
+	for i := range hosts {
+		if n := len(results[i]); n == 0 {
+			verdicts = append(verdicts, newVerdict(true, "%s had no points %s", f.Name, window))
+		} else {
+			verdicts = append(verdicts, newVerdict(false, "%s had %d points %s", f.Name, n, window))
+		}
+	}
+
+	return verdicts, nil
+}
+
+// Validate validates that LatestMetricStaleFilter has the metric name and positive seconds
+func (f *LatestMetricStaleFilter) Validate() error {
+	if len(f.Name) == 0 {
+		return errors.New("LatestMetricStaleFilter: missing name")
+	}
+
+	if f.Seconds <= 0 {
+		return errors.New("LatestMetricStaleFilter: seconds must be positive")
+	}
+
+	return nil
+}
+
+// Apply applies LatestMetricStaleFilter to the given hosts
+func (f *LatestMetricStaleFilter) Apply(m *mackerel.Client, hosts []*mackerel.Host) ([]*mackerel.Host, error) {
+	return applyExplainer(context.Background(), f, m, hosts)
+}
+
+// ApplyContext applies LatestMetricStaleFilter to the given hosts with the context
+func (f *LatestMetricStaleFilter) ApplyContext(ctx context.Context, m *mackerel.Client, hosts []*mackerel.Host) ([]*mackerel.Host, error) {
+	return applyExplainer(ctx, f, m, hosts)
+}
+
+// Explain explains LatestMetricStaleFilter on the given hosts
+func (f *LatestMetricStaleFilter) Explain(ctx context.Context, m *mackerel.Client, hosts []*mackerel.Host) ([]*Verdict, error) {
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+
+	size := f.BatchSize
+	if size <= 0 {
+		size = DefaultLatestMetricBatchSize
+	}
+
+	now := currentTime(ctx).Unix()
+	cutoff := now - f.Seconds
+
+	verdicts := make([]*Verdict, 0, len(hosts))
+
+	for start := 0; start < len(hosts); start += size {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		end := start + size
+		if end > len(hosts) {
+			end = len(hosts)
+		}
+		batch := hosts[start:end]
+
+		ids := make([]string, 0, len(batch))
+		for _, host := range batch {
+			ids = append(ids, host.ID)
+		}
 
-		values, err := m.FetchHostMetricValues(host.ID, f.Name, f.From, f.To)
+		if err := rateLimiter(ctx).Wait(ctx); err != nil {
+			return nil, err
+		}
 
+		latest, err := m.FetchLatestMetricValues(ids, []string{f.Name})
 		if err != nil {
-			return nil, errors.Wrapf(err, "MetricAbsenceFilter.Apply fails while applying a filter: host: id: %v, name: %v, metric: %v", host.ID, host.Name, f.Name)
+			return nil, errors.Wrapf(err, "LatestMetricStaleFilter.Explain fails while applying a filter: metric: %v", f.Name)
 		}
 
-		if len(values) == 0 {
-			filtered = append(filtered, host)
+		for _, host := range batch {
+			v := latest[host.ID][f.Name]
+			if v == nil {
+				verdicts = append(verdicts, newVerdict(true, "%s has no latest value", f.Name))
+				continue
+			}
+
+			age := time.Duration(now-v.Time) * time.Second
+			if v.Time < cutoff {
+				verdicts = append(verdicts, newVerdict(true, "%s was last reported %v ago, more than %ds ago", f.Name, age, f.Seconds))
+			} else {
+				verdicts = append(verdicts, newVerdict(false, "%s was last reported %v ago, within %ds", f.Name, age, f.Seconds))
+			}
+		}
+	}
+
+	return verdicts, nil
+}
+
+// Validate validates the fields and the regular expressions of NameFilter
+func (f *NameFilter) Validate() error {
+	for _, field := range f.Fields {
+		if hostName(&mackerel.Host{}, field) == nil {
+			return errors.Errorf("NameFilter: unknown field %q, must be one of %s", field, strings.Join(nameFields, ", "))
+		}
+	}
+
+	if _, err := compileAll(f.Include); err != nil {
+		return errors.Wrap(err, "NameFilter: invalid include")
+	}
+	if _, err := compileAll(f.Exclude); err != nil {
+		return errors.Wrap(err, "NameFilter: invalid exclude")
+	}
+
+	return nil
+}
+
+// Apply applies NameFilter to the given hosts
+func (f *NameFilter) Apply(m *mackerel.Client, hosts []*mackerel.Host) ([]*mackerel.Host, error) {
+	return applyExplainer(context.Background(), f, m, hosts)
+}
+
+// ApplyContext applies NameFilter to the given hosts with the context
+func (f *NameFilter) ApplyContext(ctx context.Context, m *mackerel.Client, hosts []*mackerel.Host) ([]*mackerel.Host, error) {
+	return applyExplainer(ctx, f, m, hosts)
+}
+
+// Explain explains NameFilter on the given hosts
+func (f *NameFilter) Explain(_ context.Context, _ *mackerel.Client, hosts []*mackerel.Host) ([]*Verdict, error) {
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+
+	include, _ := compileAll(f.Include)
+	exclude, _ := compileAll(f.Exclude)
+
+	fields := f.Fields
+	if len(fields) == 0 {
+		fields = nameFields
+	}
+
+	verdicts := make([]*Verdict, 0, len(hosts))
+	for _, host := range hosts {
+		if field, value, re := matchName(host, fields, exclude); re != nil {
+			verdicts = append(verdicts, newVerdict(false, "%s %q matches exclude %q", field, value, re))
+			continue
+		}
+
+		if len(include) == 0 {
+			verdicts = append(verdicts, newVerdict(true, "no include patterns given and no exclude patterns match"))
+			continue
+		}
+
+		if field, value, re := matchName(host, fields, include); re != nil {
+			verdicts = append(verdicts, newVerdict(true, "%s %q matches %q", field, value, re))
+		} else {
+			verdicts = append(verdicts, newVerdict(false, "none of %s matches %q", strings.Join(fields, ", "), f.Include))
+		}
+	}
+
+	return verdicts, nil
+}
+
+// Validate validates the glob patterns of RoleFilter
+func (f *RoleFilter) Validate() error {
+	for _, pattern := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Wrapf(err, "RoleFilter: invalid pattern %q", pattern)
+		}
+	}
+
+	return nil
+}
+
+// Apply applies RoleFilter to the given hosts
+func (f *RoleFilter) Apply(m *mackerel.Client, hosts []*mackerel.Host) ([]*mackerel.Host, error) {
+	return applyExplainer(context.Background(), f, m, hosts)
+}
+
+// ApplyContext applies RoleFilter to the given hosts with the context
+func (f *RoleFilter) ApplyContext(ctx context.Context, m *mackerel.Client, hosts []*mackerel.Host) ([]*mackerel.Host, error) {
+	return applyExplainer(ctx, f, m, hosts)
+}
+
+// Explain explains RoleFilter on the given hosts
+func (f *RoleFilter) Explain(_ context.Context, _ *mackerel.Client, hosts []*mackerel.Host) ([]*Verdict, error) {
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+
+	verdicts := make([]*Verdict, 0, len(hosts))
+	for _, host := range hosts {
+		roles := roleFullnames(host)
+
+		if role, pattern := matchRole(roles, f.Exclude); pattern != "" {
+			verdicts = append(verdicts, newVerdict(false, "role %q matches exclude %q", role, pattern))
+			continue
+		}
+
+		if len(roles) == 0 {
+			if f.NoRoles || len(f.Include) == 0 {
+				verdicts = append(verdicts, newVerdict(true, "has no roles"))
+			} else {
+				verdicts = append(verdicts, newVerdict(false, "has no roles"))
+			}
+			continue
+		}
+
+		if len(f.Include) == 0 && !f.NoRoles {
+			verdicts = append(verdicts, newVerdict(true, "no include patterns given and no exclude patterns match"))
+			continue
+		}
+
+		if role, pattern := matchRole(roles, f.Include); pattern != "" {
+			verdicts = append(verdicts, newVerdict(true, "role %q matches %q", role, pattern))
+		} else {
+			verdicts = append(verdicts, newVerdict(false, "none of roles %q matches %q", roles, f.Include))
+		}
+	}
+
+	return verdicts, nil
+}
+
+// Validate validates the statuses of StatusFilter
+func (f *StatusFilter) Validate() error {
+	if len(f.Statuses) == 0 {
+		return errors.New("StatusFilter: missing statuses")
+	}
+
+	for _, status := range f.Statuses {
+		if !containsString(hostStatuses, status) {
+			return errors.Errorf("StatusFilter: unknown status %q, must be one of %s", status, strings.Join(hostStatuses, ", "))
+		}
+	}
+
+	return nil
+}
+
+// Apply applies StatusFilter to the given hosts
+func (f *StatusFilter) Apply(m *mackerel.Client, hosts []*mackerel.Host) ([]*mackerel.Host, error) {
+	return applyExplainer(context.Background(), f, m, hosts)
+}
+
+// ApplyContext applies StatusFilter to the given hosts with the context
+func (f *StatusFilter) ApplyContext(ctx context.Context, m *mackerel.Client, hosts []*mackerel.Host) ([]*mackerel.Host, error) {
+	return applyExplainer(ctx, f, m, hosts)
+}
+
+// Explain explains StatusFilter on the given hosts
+func (f *StatusFilter) Explain(_ context.Context, _ *mackerel.Client, hosts []*mackerel.Host) ([]*Verdict, error) {
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+
+	verdicts := make([]*Verdict, 0, len(hosts))
+	for _, host := range hosts {
+		if containsString(f.Statuses, host.Status) {
+			verdicts = append(verdicts, newVerdict(true, "status is %q", host.Status))
+		} else {
+			verdicts = append(verdicts, newVerdict(false, "status is %q, not any of %q", host.Status, f.Statuses))
+		}
+	}
+
+	return verdicts, nil
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchRole returns the first role matching any of the glob patterns
+func matchRole(roles, patterns []string) (string, string) {
+	for _, role := range roles {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, role); ok {
+				return role, pattern
+			}
+		}
+	}
+
+	return "", ""
+}
+
+// hostName returns the pointer to the named host attribute, or nil when the name is unknown
+func hostName(host *mackerel.Host, field string) *string {
+	switch field {
+	case "name":
+		return &host.Name
+	case "displayName":
+		return &host.DisplayName
+	case "customIdentifier":
+		return &host.CustomIdentifier
+	default:
+		return nil
+	}
+}
+
+// matchName returns the first non-empty field of the host matching any of the regular expressions
+func matchName(host *mackerel.Host, fields []string, res []*regexp.Regexp) (string, string, *regexp.Regexp) {
+	for _, field := range fields {
+		value := *hostName(host, field)
+		if value == "" {
+			continue
+		}
+
+		for _, re := range res {
+			if re.MatchString(value) {
+				return field, value, re
+			}
+		}
+	}
+
+	return "", "", nil
+}
+
+// compileAll compiles the regular expressions
+func compileAll(exprs []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(exprs))
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
 		}
+		res = append(res, re)
 	}
 
-	return filtered, nil
+	return res, nil
 }
@@ -1,11 +1,17 @@
 package mkk
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/pkg/errors"
+
 	"github.com/shuheiktgw/mackerel-killer/test/until"
 
 	"github.com/mackerelio/mackerel-client-go"
@@ -29,14 +35,17 @@ func TestGracePeriodFilter_Apply(t *testing.T) {
 		},
 	}
 
+	now := time.Date(2019, 5, 27, 0, 0, 0, 0, time.UTC)
+	ctx := WithClock(context.Background(), FixedClock(now))
+
 	for i, tc := range cases {
 		client := mackerel.Client{}
 
 		t.Run(tc.title, func(t *testing.T) {
-			h := mackerel.Host{CreatedAt: int32(time.Now().Unix() - int64(100))}
+			h := mackerel.Host{CreatedAt: int32(now.Unix() - int64(100))}
 
 			f := GracePeriodFilter{Seconds: tc.seconds}
-			filtered, err := f.Apply(&client, []*mackerel.Host{&h})
+			filtered, err := f.ApplyContext(ctx, &client, []*mackerel.Host{&h})
 			if err != nil {
 				t.Errorf("#%d GracePeriodFilter.Apply returned error: %v", i, err)
 			}
@@ -85,6 +94,169 @@ func TestHostFilter_Apply(t *testing.T) {
 	}
 }
 
+func TestNameFilter_Apply(t *testing.T) {
+	var cases = []struct {
+		title  string
+		filter NameFilter
+		want   []string
+	}{
+		{
+			title:  "Include matches any field",
+			filter: NameFilter{Include: []string{"^ci-runner-"}},
+			want:   []string{"1", "3"},
+		},
+		{
+			title:  "Include matches only the given field",
+			filter: NameFilter{Include: []string{"^ci-runner-"}, Fields: []string{"name"}},
+			want:   []string{"1"},
+		},
+		{
+			title:  "Exclude drops matched hosts",
+			filter: NameFilter{Exclude: []string{"^web-"}},
+			want:   []string{"1"},
+		},
+		{
+			title:  "Exclude wins over include",
+			filter: NameFilter{Include: []string{"-ip-"}, Exclude: []string{"^ci-"}},
+			want:   []string{"2"},
+		},
+	}
+
+	hosts := []*mackerel.Host{
+		{ID: "1", Name: "ci-runner-1"},
+		{ID: "2", Name: "web-ip-10-0-1-23"},
+		{ID: "3", Name: "web-ip-10-0-1-24", DisplayName: "ci-runner-2"},
+	}
+
+	for i, tc := range cases {
+		client := mackerel.Client{}
+
+		t.Run(tc.title, func(t *testing.T) {
+			filtered, err := tc.filter.Apply(&client, hosts)
+			if err != nil {
+				t.Fatalf("#%d NameFilter.Apply returned error: %v", i, err)
+			}
+
+			var got []string
+			for _, h := range filtered {
+				got = append(got, h.ID)
+			}
+
+			if want := tc.want; !reflect.DeepEqual(got, want) {
+				t.Errorf("#%d invalid hosts: got: %v, want: %v", i, got, want)
+			}
+		})
+	}
+}
+
+func TestNameFilter_Validate(t *testing.T) {
+	if _, err := NewFilter("NameFilter", []byte(`{"include":["("]}`)); err == nil {
+		t.Errorf("NewFilter is supposed to return error for an invalid regular expression")
+	}
+
+	if _, err := NewFilter("NameFilter", []byte(`{"fields":["hostname"]}`)); err == nil {
+		t.Errorf("NewFilter is supposed to return error for an unknown field")
+	}
+}
+
+func TestRoleFilter_Apply(t *testing.T) {
+	var cases = []struct {
+		title  string
+		filter RoleFilter
+		want   []string
+	}{
+		{
+			title:  "Include matches service glob",
+			filter: RoleFilter{Include: []string{"prod:*"}},
+			want:   []string{"1", "2"},
+		},
+		{
+			title:  "Include matches role glob",
+			filter: RoleFilter{Include: []string{"*:batch"}},
+			want:   []string{"2", "3"},
+		},
+		{
+			title:  "NoRoles selects hosts without roles",
+			filter: RoleFilter{NoRoles: true},
+			want:   []string{"4"},
+		},
+		{
+			title:  "NoRoles and include",
+			filter: RoleFilter{Include: []string{"stg:*"}, NoRoles: true},
+			want:   []string{"3", "4"},
+		},
+		{
+			title:  "Exclude drops hosts with any matched role",
+			filter: RoleFilter{Include: []string{"*:batch"}, Exclude: []string{"prod:web"}},
+			want:   []string{"3"},
+		},
+	}
+
+	hosts := []*mackerel.Host{
+		{ID: "1", Roles: mackerel.Roles{"prod": {"web"}}},
+		{ID: "2", Roles: mackerel.Roles{"prod": {"web", "batch"}}},
+		{ID: "3", Roles: mackerel.Roles{"stg": {"batch"}}},
+		{ID: "4"},
+	}
+
+	for i, tc := range cases {
+		client := mackerel.Client{}
+
+		t.Run(tc.title, func(t *testing.T) {
+			filtered, err := tc.filter.Apply(&client, hosts)
+			if err != nil {
+				t.Fatalf("#%d RoleFilter.Apply returned error: %v", i, err)
+			}
+
+			var got []string
+			for _, h := range filtered {
+				got = append(got, h.ID)
+			}
+
+			if want := tc.want; !reflect.DeepEqual(got, want) {
+				t.Errorf("#%d invalid hosts: got: %v, want: %v", i, got, want)
+			}
+		})
+	}
+}
+
+func TestStatusFilter_Apply(t *testing.T) {
+	var cases = []struct {
+		title  string
+		status string
+		want   int
+	}{
+		{
+			title:  "Status matches",
+			status: mackerel.HostStatusPoweroff,
+			want:   1,
+		},
+		{
+			title:  "Status does not match",
+			status: mackerel.HostStatusWorking,
+			want:   0,
+		},
+	}
+
+	for i, tc := range cases {
+		client := mackerel.Client{}
+
+		t.Run(tc.title, func(t *testing.T) {
+			h := mackerel.Host{Status: tc.status}
+
+			f := StatusFilter{Statuses: []string{mackerel.HostStatusStandby, mackerel.HostStatusPoweroff}}
+			filtered, err := f.Apply(&client, []*mackerel.Host{&h})
+			if err != nil {
+				t.Errorf("#%d StatusFilter.Apply returned error: %v", i, err)
+			}
+
+			if got, want := len(filtered), tc.want; got != want {
+				t.Errorf("#%d invalid number of hosts: got: %v, want: %v", i, got, want)
+			}
+		})
+	}
+}
+
 func TestMetricExistenceFilter_Apply(t *testing.T) {
 	var cases = []struct {
 		title    string
@@ -131,3 +303,154 @@ func TestMetricExistenceFilter_Apply(t *testing.T) {
 		})
 	}
 }
+
+func TestMetricAbsenceFilter_Apply_Concurrent(t *testing.T) {
+	m, mux, _, teardown := setup()
+	defer teardown()
+
+	concurrency := 3
+	var inFlight, maxInFlight int32
+
+	// Hosts with even IDs report the metric
+	mux.HandleFunc("/api/v0/hosts/", func(w http.ResponseWriter, r *http.Request) {
+		util.TestMethod(t, r, http.MethodGet)
+
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+
+		var id int
+		fmt.Sscanf(strings.TrimPrefix(r.URL.Path, "/api/v0/hosts/"), "%d", &id)
+		if id%2 == 0 {
+			fmt.Fprint(w, `{"metrics": [{"time":1,"value":"100"}]}`)
+		} else {
+			fmt.Fprint(w, `{"metrics": []}`)
+		}
+	})
+
+	var hosts, want []*mackerel.Host
+	for i := 0; i < 20; i++ {
+		h := &mackerel.Host{ID: fmt.Sprintf("%d", i)}
+		hosts = append(hosts, h)
+		if i%2 == 1 {
+			want = append(want, h)
+		}
+	}
+
+	filter := MetricAbsenceFilter{Name: "test", From: 0, To: 100, Concurrency: concurrency, RequestsPerSecond: -1}
+	filtered, err := filter.Apply(m.Client, hosts)
+	if err != nil {
+		t.Fatalf("MetricAbsenceFilter.Apply returned error: %v", err)
+	}
+
+	if got := filtered; !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid hosts: got: %v, want: %v", got, want)
+	}
+
+	if got := atomic.LoadInt32(&maxInFlight); got > int32(concurrency) {
+		t.Errorf("too many concurrent requests: got: %v, want: <= %v", got, concurrency)
+	}
+}
+
+func TestLatestMetricStaleFilter_Apply(t *testing.T) {
+	m, mux, _, teardown := setup()
+	defer teardown()
+
+	clock := time.Date(2019, 5, 27, 0, 0, 0, 0, time.UTC)
+	ctx := WithClock(context.Background(), FixedClock(clock))
+	now := clock.Unix()
+
+	// fresh reports the metric recently, stale reports it long ago and others do not report it at all
+	latest := map[string]string{
+		"fresh": fmt.Sprintf(`{"test":{"name":"test","time":%d,"value":1}}`, now-10),
+		"stale": fmt.Sprintf(`{"test":{"name":"test","time":%d,"value":1}}`, now-1000),
+		"null":  `{"test":null}`,
+	}
+
+	var requests int32
+	mux.HandleFunc("/api/v0/tsdb/latest", func(w http.ResponseWriter, r *http.Request) {
+		util.TestMethod(t, r, http.MethodGet)
+		atomic.AddInt32(&requests, 1)
+
+		r.ParseForm()
+		if got, want := r.Form["name"], []string{"test"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("invalid metric names: got: %v, want: %v", got, want)
+		}
+
+		var values []string
+		for _, id := range r.Form["hostId"] {
+			if v, ok := latest[id]; ok {
+				values = append(values, fmt.Sprintf(`"%s":%s`, id, v))
+			}
+		}
+		fmt.Fprintf(w, `{"tsdbLatest":{%s}}`, strings.Join(values, ","))
+	})
+
+	fresh := &mackerel.Host{ID: "fresh"}
+	stale := &mackerel.Host{ID: "stale"}
+	null := &mackerel.Host{ID: "null"}
+	missing := &mackerel.Host{ID: "missing"}
+	another := &mackerel.Host{ID: "another"}
+
+	filter := LatestMetricStaleFilter{Name: "test", Seconds: 100, BatchSize: 2}
+	filtered, err := filter.ApplyContext(ctx, m.Client, []*mackerel.Host{fresh, stale, null, missing, another})
+	if err != nil {
+		t.Fatalf("LatestMetricStaleFilter.ApplyContext returned error: %v", err)
+	}
+
+	if got, want := filtered, []*mackerel.Host{stale, null, missing, another}; !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid hosts: got: %v, want: %v", got, want)
+	}
+
+	if got, want := atomic.LoadInt32(&requests), int32(3); got != want {
+		t.Errorf("invalid number of requests: got: %v, want: %v", got, want)
+	}
+}
+
+func TestLatestMetricStaleFilter_Validate(t *testing.T) {
+	if _, err := NewFilter("LatestMetricStaleFilter", []byte(`{"seconds":100}`)); err == nil {
+		t.Errorf("NewFilter is supposed to return error for a missing name")
+	}
+
+	if _, err := NewFilter("LatestMetricStaleFilter", []byte(`{"name":"loadavg5"}`)); err == nil {
+		t.Errorf("NewFilter is supposed to return error for missing seconds")
+	}
+}
+
+func TestMetricAbsenceFilter_ApplyContext_Cancel(t *testing.T) {
+	m, mux, _, teardown := setup()
+	defer teardown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Cancel the context while fetching the metric of the first host
+	var requests int32
+	mux.HandleFunc("/api/v0/hosts/", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		cancel()
+		fmt.Fprint(w, `{"metrics": []}`)
+	})
+
+	var hosts []*mackerel.Host
+	for i := 0; i < 10; i++ {
+		hosts = append(hosts, &mackerel.Host{ID: fmt.Sprintf("%d", i)})
+	}
+
+	filter := MetricAbsenceFilter{Name: "test", From: 0, To: 100, Concurrency: 1, RequestsPerSecond: -1}
+	_, err := filter.ApplyContext(ctx, m.Client, hosts)
+
+	if got, want := errors.Cause(err), context.Canceled; got != want {
+		t.Errorf("invalid error: got: %v, want: %v", got, want)
+	}
+
+	if got := atomic.LoadInt32(&requests); got != 1 {
+		t.Errorf("invalid number of requests: got: %v, want: 1", got)
+	}
+}
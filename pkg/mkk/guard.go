@@ -0,0 +1,136 @@
+package mkk
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+// RefusedError is returned when Mkk refuses to retire a host for safety
+// The host is skipped without sending any request
+type RefusedError struct {
+	Host   *mackerel.Host
+	Reason string
+
+	// Protected is set when the host is in the protection list
+	Protected bool
+}
+
+func (e *RefusedError) Error() string {
+	if e.Protected {
+		return fmt.Sprintf("protected: %s", e.Reason)
+	}
+
+	return fmt.Sprintf("refused to retire: %s", e.Reason)
+}
+
+// IsRefused reports whether the error is *RefusedError
+func IsRefused(err error) bool {
+	_, ok := err.(*RefusedError)
+	return ok
+}
+
+// Protection lists the hosts which are never retired whatever the filters say
+// Names are regular expressions matched against name, displayName and customIdentifier
+// and Roles are role fullnames such as service:role, which may be glob patterns
+type Protection struct {
+	IDs   []string `json:"ids"`
+	Names []string `json:"names"`
+	Roles []string `json:"roles"`
+}
+
+// Validate validates the name and role patterns of Protection
+func (p *Protection) Validate() error {
+	if _, err := compileAll(p.Names); err != nil {
+		return errors.Wrap(err, "invalid name pattern")
+	}
+
+	return (&RoleFilter{Include: p.Roles}).Validate()
+}
+
+// Merge returns a new Protection which protects the hosts protected by either p or o
+// Either of them may be nil
+func (p *Protection) Merge(o *Protection) *Protection {
+	var merged Protection
+
+	for _, v := range []*Protection{p, o} {
+		if v == nil {
+			continue
+		}
+
+		merged.IDs = append(merged.IDs, v.IDs...)
+		merged.Names = append(merged.Names, v.Names...)
+		merged.Roles = append(merged.Roles, v.Roles...)
+	}
+
+	return &merged
+}
+
+// protects returns the reason when the host is protected
+func (p *Protection) protects(host *mackerel.Host) (string, bool) {
+	if containsString(p.IDs, host.ID) {
+		return fmt.Sprintf("host ID %s is protected", host.ID), true
+	}
+
+	names, err := compileAll(p.Names)
+	if err != nil {
+		// Protect the host when the patterns are broken rather than retiring it
+		return fmt.Sprintf("invalid name pattern: %s", err), true
+	}
+
+	if field, value, re := matchName(host, nameFields, names); re != nil {
+		return fmt.Sprintf("%s %q matches %q", field, value, re), true
+	}
+
+	if role, pattern := matchRole(roleFullnames(host), p.Roles); pattern != "" {
+		return fmt.Sprintf("role %q matches %q", role, pattern), true
+	}
+
+	return "", false
+}
+
+// Check returns *RefusedError when the host must not be retired, which is either
+// in the protection list, working unless AllowWorking is set or protected by its metadata
+// such as {"protect": true} or {"retire_after": <epoch seconds>} in the future in MetadataNamespace
+// retire_after is compared with the time of the system rather than Clock, which only affects filters
+func (m *Mkk) Check(host *mackerel.Host) error {
+	return m.CheckContext(context.Background(), host)
+}
+
+// CheckContext is Check with the context
+// The metadata is read through RateLimiter and not read once the context is done
+func (m *Mkk) CheckContext(ctx context.Context, host *mackerel.Host) error {
+	if m.Protection != nil {
+		if reason, ok := m.Protection.protects(host); ok {
+			return &RefusedError{Host: host, Reason: reason, Protected: true}
+		}
+	}
+
+	if host.Status == mackerel.HostStatusWorking && !m.AllowWorking {
+		return &RefusedError{Host: host, Reason: "status is working"}
+	}
+
+	if m.MetadataNamespace != "" {
+		doc, err := getMetadata(m.withRateLimiter(ctx), m.Client, host.ID, m.MetadataNamespace)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			return errors.Wrapf(err, "error occurred while reading metadata: host: id: %v, name: %v", host.ID, host.Name)
+		}
+
+		if v, _ := lookupPath(doc, "protect"); v == true {
+			return &RefusedError{Host: host, Reason: fmt.Sprintf("metadata %s:protect is true", m.MetadataNamespace), Protected: true}
+		}
+
+		v, _ := lookupPath(doc, "retire_after")
+		if after, ok := v.(float64); ok && int64(after) > SystemClock.Now().Unix() {
+			return &RefusedError{Host: host, Reason: fmt.Sprintf("metadata %s:retire_after %s has not passed yet", m.MetadataNamespace, formatUnix(int64(after))), Protected: true}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package mkk
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+func TestMkk_Check(t *testing.T) {
+	protection := &Protection{
+		IDs:   []string{"bastion"},
+		Names: []string{"^db-primary-"},
+		Roles: []string{"prod:db", "*:bastion"},
+	}
+
+	var cases = []struct {
+		title        string
+		host         *mackerel.Host
+		allowWorking bool
+		wantErr      string
+	}{
+		{
+			title:   "Host ID is protected",
+			host:    &mackerel.Host{ID: "bastion", Status: mackerel.HostStatusStandby},
+			wantErr: "protected: host ID bastion is protected",
+		},
+		{
+			title:   "Display name is protected",
+			host:    &mackerel.Host{ID: "a", Name: "ip-10-0-0-1", DisplayName: "db-primary-1", Status: mackerel.HostStatusStandby},
+			wantErr: `protected: displayName "db-primary-1" matches "^db-primary-"`,
+		},
+		{
+			title:   "Role is protected",
+			host:    &mackerel.Host{ID: "a", Roles: mackerel.Roles{"stg": {"bastion"}}, Status: mackerel.HostStatusStandby},
+			wantErr: `protected: role "stg:bastion" matches "*:bastion"`,
+		},
+		{
+			title:        "Protection wins over AllowWorking",
+			host:         &mackerel.Host{ID: "a", Roles: mackerel.Roles{"prod": {"db"}}, Status: mackerel.HostStatusWorking},
+			allowWorking: true,
+			wantErr:      `protected: role "prod:db" matches "prod:db"`,
+		},
+		{
+			title:   "Working host is refused",
+			host:    &mackerel.Host{ID: "a", Status: mackerel.HostStatusWorking},
+			wantErr: "refused to retire: status is working",
+		},
+		{
+			title:        "Working host is allowed",
+			host:         &mackerel.Host{ID: "a", Status: mackerel.HostStatusWorking},
+			allowWorking: true,
+		},
+		{
+			title: "Host is not protected",
+			host:  &mackerel.Host{ID: "a", Name: "db-replica-1", Roles: mackerel.Roles{"prod": {"web"}}, Status: mackerel.HostStatusPoweroff},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(tc.title, func(t *testing.T) {
+			m := &Mkk{AllowWorking: tc.allowWorking, Protection: protection}
+
+			err := m.Check(tc.host)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("#%d Mkk.Check returned error: %v", i, err)
+				}
+				return
+			}
+
+			if !IsRefused(err) {
+				t.Fatalf("#%d Mkk.Check is supposed to return *RefusedError: got: %v", i, err)
+			}
+
+			if got, want := err.Error(), tc.wantErr; got != want {
+				t.Errorf("#%d invalid error: got: %v, want: %v", i, got, want)
+			}
+		})
+	}
+}
+
+func TestMkk_Check_RetireAfter(t *testing.T) {
+	m, mux, _, teardown := setup()
+	defer teardown()
+
+	// retire_after is compared with the time of the system even when Clock is far in the future
+	future := time.Now().Add(time.Hour).Unix()
+	past := time.Now().Add(-time.Hour).Unix()
+
+	m.MetadataNamespace = DefaultMetadataNamespace
+	m.Clock = FixedClock(time.Now().AddDate(10, 0, 0))
+	handleMetadata(mux, map[string]string{
+		"future": fmt.Sprintf(`{"retire_after": %d}`, future),
+		"past":   fmt.Sprintf(`{"retire_after": %d}`, past),
+		"string": `{"retire_after": "tomorrow"}`,
+	})
+
+	err := m.Check(&mackerel.Host{ID: "future"})
+	if !IsRefused(err) {
+		t.Fatalf("Mkk.Check is supposed to return *RefusedError: got: %v", err)
+	}
+
+	if got, want := err.Error(), fmt.Sprintf("protected: metadata mackerel-killer:retire_after %s has not passed yet", formatUnix(future)); got != want {
+		t.Errorf("invalid error: got: %v, want: %v", got, want)
+	}
+
+	for _, id := range []string{"past", "string", "none"} {
+		if err := m.Check(&mackerel.Host{ID: id}); err != nil {
+			t.Errorf("Mkk.Check returned error for host %s: %v", id, err)
+		}
+	}
+}
+
+func TestMkk_CheckContext(t *testing.T) {
+	m, mux, _, teardown := setup()
+	defer teardown()
+
+	m.MetadataNamespace = DefaultMetadataNamespace
+	m.RateLimiter = NewRateLimiter(1, 1)
+	handleMetadata(mux, map[string]string{})
+
+	// The first check takes the only token, so the next one waits for the limiter until the deadline
+	if err := m.CheckContext(context.Background(), &mackerel.Host{ID: "a"}); err != nil {
+		t.Fatalf("Mkk.CheckContext returned error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if got, want := m.CheckContext(ctx, &mackerel.Host{ID: "b"}), context.DeadlineExceeded; got != want {
+		t.Errorf("invalid error: got: %v, want: %v", got, want)
+	}
+
+	if got, want := m.CheckContext(ctx, &mackerel.Host{ID: "c"}), context.DeadlineExceeded; got != want {
+		t.Errorf("invalid error after the deadline: got: %v, want: %v", got, want)
+	}
+}
+
+func TestProtection_Validate(t *testing.T) {
+	if err := (&Protection{Names: []string{"("}}).Validate(); err == nil {
+		t.Errorf("Protection.Validate is supposed to return error for an invalid name pattern")
+	}
+
+	if err := (&Protection{Roles: []string{"prod:["}}).Validate(); err == nil {
+		t.Errorf("Protection.Validate is supposed to return error for an invalid role pattern")
+	}
+}
@@ -0,0 +1,32 @@
+// +build !integration
+
+package mkk
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/shuheiktgw/mackerel-killer/pkg/mkktest"
+)
+
+var (
+	integrationMkk *Mkk
+
+	// metricDelay is how long to wait until posted metrics become available
+	metricDelay time.Duration
+)
+
+// TestMain runs the integration scenarios against a fake Mackerel API unless the integration tag is given
+func TestMain(m *testing.M) {
+	server := mkktest.NewServer()
+
+	integrationMkk = NewMkk("")
+	integrationMkk.Client.BaseURL = server.BaseURL()
+	integrationMkk.AllowWorking = true
+
+	code := m.Run()
+
+	server.Close()
+	os.Exit(code)
+}
@@ -2,13 +2,20 @@
 
 package mkk
 
-import "os"
+import (
+	"os"
+	"time"
+)
 
 var (
 	integrationMackerelToken = os.Getenv("MACKEREL_API_TOKEN")
 	integrationMkk           *Mkk
+
+	// metricDelay is how long to wait until posted metrics become available
+	metricDelay = 20 * time.Second
 )
 
 func init() {
 	integrationMkk = NewMkk(integrationMackerelToken)
+	integrationMkk.AllowWorking = true
 }
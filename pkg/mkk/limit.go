@@ -0,0 +1,38 @@
+package mkk
+
+import "fmt"
+
+// RetireLimit is a safety cap on the number of hosts retired by a single run
+// Zero values disable the corresponding limits
+type RetireLimit struct {
+	// Max is the maximum number of hosts to retire
+	Max int
+
+	// MaxPercent is the maximum percentage of hosts to retire out of the hosts found before filtering
+	MaxPercent float64
+}
+
+// LimitExceededError is returned when the hosts to retire exceed RetireLimit
+type LimitExceededError struct {
+	Selected int
+	Total    int
+	Limit    string
+}
+
+func (e *LimitExceededError) Error() string {
+	return fmt.Sprintf("%d out of %d hosts are selected to retire, which exceeds %s", e.Selected, e.Total, e.Limit)
+}
+
+// Check checks the number of selected hosts out of total found hosts against the limits
+// It returns *LimitExceededError when either of the limits is exceeded
+func (l *RetireLimit) Check(selected, total int) error {
+	if l.Max > 0 && selected > l.Max {
+		return &LimitExceededError{Selected: selected, Total: total, Limit: fmt.Sprintf("the limit of %d hosts", l.Max)}
+	}
+
+	if l.MaxPercent > 0 && total > 0 && float64(selected)*100 > l.MaxPercent*float64(total) {
+		return &LimitExceededError{Selected: selected, Total: total, Limit: fmt.Sprintf("the limit of %g%% of hosts", l.MaxPercent)}
+	}
+
+	return nil
+}
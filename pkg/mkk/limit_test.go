@@ -0,0 +1,67 @@
+package mkk
+
+import "testing"
+
+func TestRetireLimit_Check(t *testing.T) {
+	var cases = []struct {
+		title    string
+		limit    RetireLimit
+		selected int
+		total    int
+		error    bool
+	}{
+		{
+			title:    "No limits",
+			limit:    RetireLimit{},
+			selected: 100,
+			total:    100,
+		},
+		{
+			title:    "Within the max",
+			limit:    RetireLimit{Max: 10},
+			selected: 10,
+			total:    100,
+		},
+		{
+			title:    "Exceeds the max",
+			limit:    RetireLimit{Max: 10},
+			selected: 11,
+			total:    100,
+			error:    true,
+		},
+		{
+			title:    "Within the max percent",
+			limit:    RetireLimit{MaxPercent: 10},
+			selected: 10,
+			total:    100,
+		},
+		{
+			title:    "Exceeds the max percent",
+			limit:    RetireLimit{MaxPercent: 10},
+			selected: 11,
+			total:    100,
+			error:    true,
+		},
+		{
+			title:    "Exceeds the max percent within the max",
+			limit:    RetireLimit{Max: 10, MaxPercent: 50},
+			selected: 3,
+			total:    4,
+			error:    true,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(tc.title, func(t *testing.T) {
+			err := tc.limit.Check(tc.selected, tc.total)
+
+			if tc.error {
+				if _, ok := err.(*LimitExceededError); !ok {
+					t.Errorf("#%d Check is supposed to return LimitExceededError: got: %v", i, err)
+				}
+			} else if err != nil {
+				t.Errorf("#%d Check returned error: %v", i, err)
+			}
+		})
+	}
+}
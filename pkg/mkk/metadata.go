@@ -0,0 +1,236 @@
+package mkk
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+// DefaultMetadataNamespace is the default namespace of the host metadata read by mkk
+const DefaultMetadataNamespace = "mackerel-killer"
+
+// Operators of MetadataFilter
+const (
+	MetadataOpExists  = "exists"
+	MetadataOpMissing = "missing"
+	MetadataOpEq      = "eq"
+	MetadataOpNe      = "ne"
+	MetadataOpLt      = OpLt
+	MetadataOpLte     = OpLte
+	MetadataOpGt      = OpGt
+	MetadataOpGte     = OpGte
+	MetadataOpElapsed = "elapsed"
+)
+
+var metadataOps = []string{
+	MetadataOpExists, MetadataOpMissing, MetadataOpEq, MetadataOpNe,
+	MetadataOpLt, MetadataOpLte, MetadataOpGt, MetadataOpGte, MetadataOpElapsed,
+}
+
+// MetadataFilter selects hosts by the host metadata in Namespace, which is mackerel-killer by default
+// Path is a dot separated path to a value in the metadata such as retire_after or owner.team,
+// optionally prefixed with $., and array elements are addressed by their indexes like tags.0
+// Op compares the value with Value and is one of exists, missing, eq, ne, lt, lte, gt, gte and elapsed,
+// which selects epoch seconds already passed such as {"retire_after": 1558910000}
+// Op defaults to eq when Value is given and exists otherwise
+type MetadataFilter struct {
+	Namespace string
+	Path      string
+	Op        string
+	Value     interface{}
+}
+
+// Validate validates the operator and the value of MetadataFilter
+func (f *MetadataFilter) Validate() error {
+	op := f.op()
+
+	if !containsString(metadataOps, op) {
+		return errors.Errorf("MetadataFilter: unknown op %q, must be one of %s", op, strings.Join(metadataOps, ", "))
+	}
+
+	switch op {
+	case MetadataOpLt, MetadataOpLte, MetadataOpGt, MetadataOpGte:
+		if _, ok := normalizeJSON(f.Value).(float64); !ok {
+			return errors.Errorf("MetadataFilter: op %q requires a number value, got %v", op, f.Value)
+		}
+	}
+
+	return nil
+}
+
+func (f *MetadataFilter) op() string {
+	switch {
+	case f.Op != "":
+		return f.Op
+	case f.Value != nil:
+		return MetadataOpEq
+	default:
+		return MetadataOpExists
+	}
+}
+
+func (f *MetadataFilter) namespace() string {
+	if f.Namespace == "" {
+		return DefaultMetadataNamespace
+	}
+
+	return f.Namespace
+}
+
+// Apply applies MetadataFilter to the given hosts
+func (f *MetadataFilter) Apply(m *mackerel.Client, hosts []*mackerel.Host) ([]*mackerel.Host, error) {
+	return applyExplainer(context.Background(), f, m, hosts)
+}
+
+// ApplyContext applies MetadataFilter to the given hosts with the context
+func (f *MetadataFilter) ApplyContext(ctx context.Context, m *mackerel.Client, hosts []*mackerel.Host) ([]*mackerel.Host, error) {
+	return applyExplainer(ctx, f, m, hosts)
+}
+
+// Explain explains MetadataFilter on the given hosts
+func (f *MetadataFilter) Explain(ctx context.Context, m *mackerel.Client, hosts []*mackerel.Host) ([]*Verdict, error) {
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+
+	op, ns := f.op(), f.namespace()
+	want := normalizeJSON(f.Value)
+	now := float64(currentTime(ctx).Unix())
+
+	verdicts := make([]*Verdict, 0, len(hosts))
+	for _, host := range hosts {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		doc, err := getMetadata(ctx, m, host.ID, ns)
+		if err != nil {
+			return nil, errors.Wrapf(err, "MetadataFilter.Explain fails while applying a filter: host: id: %v, name: %v", host.ID, host.Name)
+		}
+
+		v, ok := lookupPath(doc, f.Path)
+		if !ok {
+			verdicts = append(verdicts, newVerdict(op == MetadataOpMissing, "%s:%s is missing", ns, f.Path))
+			continue
+		}
+
+		var selected bool
+		switch op {
+		case MetadataOpExists:
+			selected = true
+		case MetadataOpMissing:
+			selected = false
+		case MetadataOpEq:
+			selected = reflect.DeepEqual(v, want)
+		case MetadataOpNe:
+			selected = !reflect.DeepEqual(v, want)
+		default:
+			n, isNumber := v.(float64)
+			if !isNumber {
+				verdicts = append(verdicts, newVerdict(false, "%s:%s is %s, not a number", ns, f.Path, formatJSON(v)))
+				continue
+			}
+
+			if op == MetadataOpElapsed {
+				selected = n <= now
+			} else {
+				selected = compareNumbers(op, n, want.(float64))
+			}
+		}
+
+		switch {
+		case op == MetadataOpElapsed && selected:
+			verdicts = append(verdicts, newVerdict(true, "%s:%s %s has passed", ns, f.Path, formatUnix(int64(v.(float64)))))
+		case op == MetadataOpElapsed:
+			verdicts = append(verdicts, newVerdict(false, "%s:%s %s has not passed yet", ns, f.Path, formatUnix(int64(v.(float64)))))
+		default:
+			verdicts = append(verdicts, newVerdict(selected, "%s:%s is %s, %s %s", ns, f.Path, formatJSON(v), op, formatJSON(want)))
+		}
+	}
+
+	return verdicts, nil
+}
+
+// getMetadata fetches the host metadata in the namespace after waiting for the rate limiter in the context
+// It returns nil without error when the host does not have the metadata
+func getMetadata(ctx context.Context, m *mackerel.Client, hostID, namespace string) (interface{}, error) {
+	if err := rateLimiter(ctx).Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	resp, err := m.GetHostMetaData(hostID, namespace)
+	if err != nil {
+		if apiErr, ok := err.(*mackerel.APIError); ok && apiErr.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return normalizeJSON(resp.HostMetaData), nil
+}
+
+// lookupPath returns the value at the dot separated path in the JSON document
+func lookupPath(doc interface{}, path string) (interface{}, bool) {
+	if doc == nil {
+		return nil, false
+	}
+
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return doc, true
+	}
+
+	v := doc
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			child, ok := node[key]
+			if !ok {
+				return nil, false
+			}
+			v = child
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+
+	return v, true
+}
+
+// normalizeJSON converts the value into the types encoding/json decodes into interface{}
+// so that values given in Go and in JSON can be compared
+func normalizeJSON(v interface{}) interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+
+	var n interface{}
+	if err := json.Unmarshal(b, &n); err != nil {
+		return v
+	}
+
+	return n
+}
+
+func formatJSON(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "?"
+	}
+
+	return string(b)
+}
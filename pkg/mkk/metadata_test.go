@@ -0,0 +1,131 @@
+package mkk
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+// handleMetadata serves the host metadata in the mackerel-killer namespace keyed by host IDs
+// Hosts without metadata get 404 as Mackerel does
+func handleMetadata(mux *http.ServeMux, metadata map[string]string) {
+	mux.HandleFunc("/api/v0/hosts/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v0/hosts/"), "/")[0]
+
+		doc, ok := metadata[id]
+		if !ok || !strings.HasSuffix(r.URL.Path, "/metadata/"+DefaultMetadataNamespace) {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error": {"message": "Metadata not found"}}`)
+			return
+		}
+
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		fmt.Fprint(w, doc)
+	})
+}
+
+func TestMetadataFilter_Apply(t *testing.T) {
+	past, future := time.Now().Add(-time.Hour).Unix(), time.Now().Add(time.Hour).Unix()
+
+	metadata := map[string]string{
+		"a": fmt.Sprintf(`{"retire_after": %d, "owner": {"team": "sre"}}`, past),
+		"b": fmt.Sprintf(`{"retire_after": %d, "owner": {"team": "web"}}`, future),
+		"c": `{"protect": true, "tags": ["db", "primary"]}`,
+	}
+
+	var cases = []struct {
+		title  string
+		filter MetadataFilter
+		want   []string
+	}{
+		{
+			title:  "Exists",
+			filter: MetadataFilter{Path: "retire_after"},
+			want:   []string{"a", "b"},
+		},
+		{
+			title:  "Missing",
+			filter: MetadataFilter{Path: "$.retire_after", Op: MetadataOpMissing},
+			want:   []string{"c", "d"},
+		},
+		{
+			title:  "Equals nested value",
+			filter: MetadataFilter{Path: "owner.team", Value: "sre"},
+			want:   []string{"a"},
+		},
+		{
+			title:  "Equals array element",
+			filter: MetadataFilter{Path: "tags.1", Value: "primary"},
+			want:   []string{"c"},
+		},
+		{
+			title:  "Greater than",
+			filter: MetadataFilter{Path: "retire_after", Op: MetadataOpGt, Value: past},
+			want:   []string{"b"},
+		},
+		{
+			title:  "Elapsed",
+			filter: MetadataFilter{Path: "retire_after", Op: MetadataOpElapsed},
+			want:   []string{"a"},
+		},
+	}
+
+	hosts := []*mackerel.Host{{ID: "a"}, {ID: "b"}, {ID: "c"}, {ID: "d"}}
+
+	for i, tc := range cases {
+		t.Run(tc.title, func(t *testing.T) {
+			m, mux, _, teardown := setup()
+			defer teardown()
+
+			handleMetadata(mux, metadata)
+
+			filtered, err := tc.filter.Apply(m.Client, hosts)
+			if err != nil {
+				t.Fatalf("#%d MetadataFilter.Apply returned error: %v", i, err)
+			}
+
+			var got []string
+			for _, h := range filtered {
+				got = append(got, h.ID)
+			}
+
+			if want := tc.want; !reflect.DeepEqual(got, want) {
+				t.Errorf("#%d invalid hosts: got: %v, want: %v", i, got, want)
+			}
+		})
+	}
+}
+
+func TestMetadataFilter_Validate(t *testing.T) {
+	if _, err := NewFilter("MetadataFilter", []byte(`{"path":"a","op":"like"}`)); err == nil {
+		t.Errorf("NewFilter is supposed to return error for an unknown op")
+	}
+
+	if _, err := NewFilter("MetadataFilter", []byte(`{"path":"a","op":"lt","value":"1"}`)); err == nil {
+		t.Errorf("NewFilter is supposed to return error for a non number value")
+	}
+}
+
+func TestMkk_Check_Metadata(t *testing.T) {
+	m, mux, _, teardown := setup()
+	defer teardown()
+
+	m.MetadataNamespace = DefaultMetadataNamespace
+	handleMetadata(mux, map[string]string{"a": `{"protect": true}`, "b": `{"protect": false}`})
+
+	err := m.Check(&mackerel.Host{ID: "a"})
+	if got, want := fmt.Sprint(err), "protected: metadata mackerel-killer:protect is true"; got != want {
+		t.Errorf("invalid error: got: %v, want: %v", got, want)
+	}
+
+	for _, id := range []string{"b", "c"} {
+		if err := m.Check(&mackerel.Host{ID: id}); err != nil {
+			t.Errorf("Mkk.Check returned error for host %s: %v", id, err)
+		}
+	}
+}
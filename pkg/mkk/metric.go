@@ -0,0 +1,261 @@
+package mkk
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+// Reducers of MetricThresholdFilter
+const (
+	ReducerAvg  = "avg"
+	ReducerMax  = "max"
+	ReducerMin  = "min"
+	ReducerP95  = "p95"
+	ReducerLast = "last"
+)
+
+var reducers = []string{ReducerAvg, ReducerMax, ReducerMin, ReducerP95, ReducerLast}
+
+// Comparison operators of MetricThresholdFilter
+const (
+	OpLt  = "lt"
+	OpLte = "lte"
+	OpGt  = "gt"
+	OpGte = "gte"
+)
+
+var comparisonOps = []string{OpLt, OpLte, OpGt, OpGte}
+
+// MetricThresholdFilter selects hosts whose values of the specified metric between From and To,
+// reduced by Reducer, satisfy Op with Threshold, e.g. max of cpu.user.percentage lt 2 from -7d
+// From and To are epoch seconds, which may also be given relative to now in JSON or FromTime and ToTime, and To defaults to now
+// Reducer is one of avg, max, min, p95 and last, which is avg by default
+// Op is one of lt, lte, gt and gte, which is lt by default
+// Hosts without any points are not selected, see MetricAbsenceFilter for them
+// Metrics are fetched in the same way as MetricAbsenceFilter
+type MetricThresholdFilter struct {
+	Name      string
+	From      int64
+	To        int64
+	Reducer   string
+	Op        string
+	Threshold float64
+
+	Concurrency       int
+	RequestsPerSecond float64
+
+	// FromTime and ToTime take precedence over From and To unless they are zero,
+	// which may be relative to now such as Relative(-24 * time.Hour)
+	FromTime Time `json:"-"`
+	ToTime   Time `json:"-"`
+}
+
+// UnmarshalJSON decodes MetricThresholdFilter whose from and to are given in any form accepted by Time
+func (f *MetricThresholdFilter) UnmarshalJSON(b []byte) error {
+	// plain does not have UnmarshalJSON, and its From and To are shadowed by Time
+	type plain MetricThresholdFilter
+	var v struct {
+		plain
+		From Time
+		To   Time
+	}
+
+	if err := decodeStrict(b, &v); err != nil {
+		return err
+	}
+
+	*f = MetricThresholdFilter(v.plain)
+	f.From, f.FromTime = splitTime(v.From)
+	f.To, f.ToTime = splitTime(v.To)
+
+	return nil
+}
+
+// window returns From and To of MetricThresholdFilter where FromTime and ToTime take precedence
+func (f *MetricThresholdFilter) window() (Time, Time) {
+	return metricWindow(f.From, f.FromTime, f.To, f.ToTime)
+}
+
+func (f *MetricThresholdFilter) String() string {
+	from, to := f.window()
+	return fmt.Sprintf("MetricThresholdFilter{Name:%s From:%v To:%v Reducer:%s Op:%s Threshold:%g}", f.Name, from, to, f.reducer(), f.op(), f.Threshold)
+}
+
+// Validate validates the time window, the reducer and the operator of MetricThresholdFilter
+func (f *MetricThresholdFilter) Validate() error {
+	if len(f.Name) == 0 {
+		return errors.New("MetricThresholdFilter: missing name")
+	}
+
+	from, to := f.window()
+	if from.IsZero() {
+		return errors.New("MetricThresholdFilter: missing from")
+	}
+
+	if to.IsZero() {
+		to = Relative(0)
+	}
+
+	// Absolute and relative times cannot be compared until the filter is evaluated
+	if from.relative == to.relative && from.Resolve(time.Unix(0, 0)) >= to.Resolve(time.Unix(0, 0)) {
+		return errors.Errorf("MetricThresholdFilter: from %v must be before to %v", from, to)
+	}
+
+	if r := f.reducer(); !containsString(reducers, r) {
+		return errors.Errorf("MetricThresholdFilter: unknown reducer %q, must be one of %s", r, strings.Join(reducers, ", "))
+	}
+
+	if op := f.op(); !containsString(comparisonOps, op) {
+		return errors.Errorf("MetricThresholdFilter: unknown op %q, must be one of %s", op, strings.Join(comparisonOps, ", "))
+	}
+
+	return nil
+}
+
+func (f *MetricThresholdFilter) reducer() string {
+	if f.Reducer == "" {
+		return ReducerAvg
+	}
+
+	return f.Reducer
+}
+
+func (f *MetricThresholdFilter) op() string {
+	if f.Op == "" {
+		return OpLt
+	}
+
+	return f.Op
+}
+
+// Apply applies MetricThresholdFilter to the given hosts
+func (f *MetricThresholdFilter) Apply(m *mackerel.Client, hosts []*mackerel.Host) ([]*mackerel.Host, error) {
+	return applyExplainer(context.Background(), f, m, hosts)
+}
+
+// ApplyContext applies MetricThresholdFilter to the given hosts with the context
+func (f *MetricThresholdFilter) ApplyContext(ctx context.Context, m *mackerel.Client, hosts []*mackerel.Host) ([]*mackerel.Host, error) {
+	return applyExplainer(ctx, f, m, hosts)
+}
+
+// Explain explains MetricThresholdFilter on the given hosts
+func (f *MetricThresholdFilter) Explain(ctx context.Context, m *mackerel.Client, hosts []*mackerel.Host) ([]*Verdict, error) {
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+
+	fromTime, toTime := f.window()
+	from, to := resolveWindow(fromTime, toTime, currentTime(ctx))
+
+	results, err := newMetricFetcher(ctx, f.Concurrency, f.RequestsPerSecond).fetch(ctx, m, hosts, f.Name, from, to)
+	if err != nil {
+		return nil, errors.Wrap(err, "MetricThresholdFilter.Explain fails while applying a filter")
+	}
+
+	reducer, op := f.reducer(), f.op()
+	window := fmt.Sprintf("between %s and %s", formatUnix(from), formatUnix(to))
+
+	verdicts := make([]*Verdict, 0, len(hosts))
+	for i := range hosts {
+		values, err := metricNumbers(results[i])
+		if err != nil {
+			return nil, errors.Wrapf(err, "MetricThresholdFilter.Explain fails while reading %s of host %s", f.Name, hosts[i].ID)
+		}
+
+		if len(values) == 0 {
+			verdicts = append(verdicts, newVerdict(false, "%s had no points %s", f.Name, window))
+			continue
+		}
+
+		v := reduce(reducer, values)
+		selected := compareNumbers(op, v, f.Threshold)
+		verdicts = append(verdicts, newVerdict(selected, "%s of %s was %g %s, %s %g", reducer, f.Name, v, window, op, f.Threshold))
+	}
+
+	return verdicts, nil
+}
+
+// metricNumbers converts the metric values into numbers in the order of their time
+func metricNumbers(values []mackerel.MetricValue) ([]float64, error) {
+	sorted := append([]mackerel.MetricValue{}, values...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time < sorted[j].Time })
+
+	numbers := make([]float64, 0, len(sorted))
+	for _, v := range sorted {
+		switch n := v.Value.(type) {
+		case float64:
+			numbers = append(numbers, n)
+		case int64:
+			numbers = append(numbers, float64(n))
+		case int:
+			numbers = append(numbers, float64(n))
+		case string:
+			f, err := strconv.ParseFloat(n, 64)
+			if err != nil {
+				return nil, err
+			}
+			numbers = append(numbers, f)
+		default:
+			return nil, errors.Errorf("unsupported metric value %v", v.Value)
+		}
+	}
+
+	return numbers, nil
+}
+
+// reduce reduces the values in the order of their time into a single value
+// values must not be empty
+func reduce(reducer string, values []float64) float64 {
+	switch reducer {
+	case ReducerMax:
+		max := values[0]
+		for _, v := range values[1:] {
+			max = math.Max(max, v)
+		}
+		return max
+	case ReducerMin:
+		min := values[0]
+		for _, v := range values[1:] {
+			min = math.Min(min, v)
+		}
+		return min
+	case ReducerP95:
+		sorted := append([]float64{}, values...)
+		sort.Float64s(sorted)
+		// nearest-rank method
+		return sorted[int(math.Ceil(0.95*float64(len(sorted))))-1]
+	case ReducerLast:
+		return values[len(values)-1]
+	default:
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum / float64(len(values))
+	}
+}
+
+// compareNumbers compares a with b by the operator, which is one of lt, lte, gt and gte
+func compareNumbers(op string, a, b float64) bool {
+	switch op {
+	case OpLt:
+		return a < b
+	case OpLte:
+		return a <= b
+	case OpGt:
+		return a > b
+	case OpGte:
+		return a >= b
+	default:
+		return false
+	}
+}
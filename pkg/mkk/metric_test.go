@@ -0,0 +1,114 @@
+package mkk
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/shuheiktgw/mackerel-killer/test/until"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+func TestMetricThresholdFilter_Apply(t *testing.T) {
+	// a is idle, b has a spike and c is busy
+	metrics := map[string]string{
+		"a": `[{"time":1,"value":1.5},{"time":2,"value":0.5},{"time":3,"value":1}]`,
+		"b": `[{"time":3,"value":1},{"time":1,"value":0.5},{"time":2,"value":40}]`,
+		"c": `[{"time":1,"value":50},{"time":2,"value":60},{"time":3,"value":70}]`,
+		"d": `[]`,
+	}
+
+	var cases = []struct {
+		title  string
+		filter MetricThresholdFilter
+		want   []string
+	}{
+		{
+			title:  "Max",
+			filter: MetricThresholdFilter{Reducer: ReducerMax, Threshold: 2},
+			want:   []string{"a"},
+		},
+		{
+			title:  "Avg by default",
+			filter: MetricThresholdFilter{Threshold: 20},
+			want:   []string{"a", "b"},
+		},
+		{
+			title:  "Min",
+			filter: MetricThresholdFilter{Reducer: ReducerMin, Op: OpGte, Threshold: 1},
+			want:   []string{"c"},
+		},
+		{
+			title:  "P95",
+			filter: MetricThresholdFilter{Reducer: ReducerP95, Op: OpLte, Threshold: 40},
+			want:   []string{"a", "b"},
+		},
+		{
+			title:  "Last in the order of time",
+			filter: MetricThresholdFilter{Reducer: ReducerLast, Op: OpLte, Threshold: 1},
+			want:   []string{"a", "b"},
+		},
+	}
+
+	hosts := []*mackerel.Host{{ID: "a"}, {ID: "b"}, {ID: "c"}, {ID: "d"}}
+
+	for i, tc := range cases {
+		t.Run(tc.title, func(t *testing.T) {
+			m, mux, _, teardown := setup()
+			defer teardown()
+
+			mux.HandleFunc("/api/v0/hosts/", func(w http.ResponseWriter, r *http.Request) {
+				util.TestMethod(t, r, http.MethodGet)
+				util.TestFormValues(t, r, util.Values{"name": "cpu.user.percentage", "from": "1", "to": "100"})
+
+				id := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v0/hosts/"), "/")[0]
+				fmt.Fprintf(w, `{"metrics": %s}`, metrics[id])
+			})
+
+			f := tc.filter
+			f.Name, f.From, f.To = "cpu.user.percentage", 1, 100
+
+			filtered, err := f.Apply(m.Client, hosts)
+			if err != nil {
+				t.Fatalf("#%d MetricThresholdFilter.Apply returned error: %v", i, err)
+			}
+
+			var got []string
+			for _, h := range filtered {
+				got = append(got, h.ID)
+			}
+
+			if want := tc.want; !reflect.DeepEqual(got, want) {
+				t.Errorf("#%d invalid hosts: got: %v, want: %v", i, got, want)
+			}
+		})
+	}
+}
+
+func TestMetricThresholdFilter_Validate(t *testing.T) {
+	cases := []struct {
+		params string
+		error  bool
+	}{
+		{params: `{"name":"loadavg5","from":1,"reducer":"median"}`, error: true},
+		{params: `{"name":"loadavg5","from":1,"op":"eq"}`, error: true},
+		{params: `{"name":"loadavg5"}`, error: true},
+		{params: `{"name":"loadavg5","from":100,"to":100}`, error: true},
+		{params: `{"name":"loadavg5","from":"-1h","to":"-2h"}`, error: true},
+		{params: `{"name":"loadavg5","from":"+1h"}`, error: true},
+		{params: `{"name":"loadavg5","from":1,"to":100}`, error: false},
+		{params: `{"name":"loadavg5","from":"-7d"}`, error: false},
+		{params: `{"name":"loadavg5","from":"-2h","to":"-1h"}`, error: false},
+		{params: `{"name":"loadavg5","from":1558915200,"to":"now"}`, error: false},
+	}
+
+	for i, tc := range cases {
+		_, err := NewFilter("MetricThresholdFilter", []byte(tc.params))
+		if got, want := err != nil, tc.error; got != want {
+			t.Errorf("#%d invalid error: params: %s, got: %v, want: %v", i, tc.params, err, want)
+		}
+	}
+}
@@ -1,31 +1,97 @@
 package mkk
 
 import (
+	"context"
+
 	"github.com/mackerelio/mackerel-client-go"
 	"github.com/pkg/errors"
 )
 
+// DefaultBulkRetireSize is the default number of hosts retired by a single bulk-retire request
+const DefaultBulkRetireSize = 50
+
 // Mkk is a wrapper for mackerel.Client to retire the inactive Mackerel hosts
+// Requests sent by Client are retried by Retry
 type Mkk struct {
 	Client *mackerel.Client
+	Retry  *RetryTransport
+
+	// BulkRetireSize is the number of hosts retired by a single request in KillAll
+	BulkRetireSize int
+
+	// AllowWorking allows retiring hosts whose status is working
+	// Such hosts are refused with *RefusedError unless it is set
+	AllowWorking bool
+
+	// Protection lists the hosts which are refused with *RefusedError
+	Protection *Protection
+
+	// Clock tells filters the current time, which is SystemClock when nil
+	Clock Clock
+
+	// RateLimiter limits the requests sent by all the filters and Check for the whole lifetime of Mkk,
+	// unlike RequestsPerSecond of each filter, which is disabled when nil
+	RateLimiter *RateLimiter
+
+	// MetadataNamespace is the namespace of the host metadata checked for {"protect": true}
+	// and {"retire_after": <epoch seconds>} in the future before retiring a host, which is disabled when empty
+	MetadataNamespace string
+}
+
+// KillResult is the result of retiring a host with KillAll
+type KillResult struct {
+	Host *mackerel.Host
+	Err  error
 }
 
 // NewMkk initializes Mkk
 func NewMkk(token string) *Mkk {
-	return &Mkk{Client: mackerel.NewClient(token)}
+	client := mackerel.NewClient(token)
+
+	retry := NewRetryTransport(client.HTTPClient.Transport)
+	client.HTTPClient.Transport = retry
+
+	// Each attempt is limited by retry.Timeout instead, so that retries are not cut off
+	client.HTTPClient.Timeout = 0
+
+	return &Mkk{
+		Client:         client,
+		Retry:          retry,
+		BulkRetireSize: DefaultBulkRetireSize,
+		RateLimiter:    NewRateLimiter(DefaultRequestsPerSecond, DefaultConcurrency),
+	}
 }
 
 // FindHosts finds hosts with mackerel.FindHostsParam and given filters
 func (m *Mkk) FindHosts(param *mackerel.FindHostsParam, filters []Filter) ([]*mackerel.Host, error) {
+	return m.FindHostsContext(context.Background(), param, filters)
+}
+
+// FindHostsContext is FindHosts with the context
+func (m *Mkk) FindHostsContext(ctx context.Context, param *mackerel.FindHostsParam, filters []Filter) ([]*mackerel.Host, error) {
 	hosts, err := m.Client.FindHosts(param)
 	if err != nil {
 		return nil, errors.Wrap(err, "Mkk.FindHosts fails while finding hosts")
 	}
 
+	return m.FilterContext(ctx, hosts, filters)
+}
+
+// Filter applies the given filters to hosts in order
+func (m *Mkk) Filter(hosts []*mackerel.Host, filters []Filter) ([]*mackerel.Host, error) {
+	return m.FilterContext(context.Background(), hosts, filters)
+}
+
+// FilterContext is Filter with the context
+func (m *Mkk) FilterContext(ctx context.Context, hosts []*mackerel.Host, filters []Filter) ([]*mackerel.Host, error) {
+	ctx = m.filterContext(ctx)
+
+	var err error
+
 	for _, f := range filters {
-		hosts, err = f.Apply(m.Client, hosts)
+		hosts, err = applyContext(ctx, f, m.Client, hosts)
 		if err != nil {
-			return nil, errors.Wrap(err, "Mkk.FindHosts fails while applying filters")
+			return nil, errors.Wrap(err, "Mkk.Filter fails while applying filters")
 		}
 	}
 
@@ -34,5 +100,102 @@ func (m *Mkk) FindHosts(param *mackerel.FindHostsParam, filters []Filter) ([]*ma
 
 // Kill retires specified Mackerel host
 func (m *Mkk) Kill(host *mackerel.Host) error {
+	return m.KillContext(context.Background(), host)
+}
+
+// KillContext retires specified Mackerel host unless the context is done
+// It returns *RefusedError without retiring the host when the host must not be retired
+func (m *Mkk) KillContext(ctx context.Context, host *mackerel.Host) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := m.CheckContext(ctx, host); err != nil {
+		return err
+	}
+
 	return m.Client.RetireHost(host.ID)
 }
+
+// KillAll retires the given hosts in batches of BulkRetireSize using the bulk-retire endpoint
+// When a batch fails, the hosts in the batch are retired one by one
+// Hosts which must not be retired are skipped with *RefusedError
+// The results are returned in the same order as hosts
+func (m *Mkk) KillAll(hosts []*mackerel.Host) []*KillResult {
+	results, _ := m.KillAllContext(context.Background(), hosts)
+	return results
+}
+
+// KillAllContext is KillAll with the context
+// When the context is done, it stops after the in-flight request
+// and returns the results of the hosts tried so far along with the error of the context
+func (m *Mkk) KillAllContext(ctx context.Context, hosts []*mackerel.Host) ([]*KillResult, error) {
+	size := m.BulkRetireSize
+	if size <= 0 {
+		size = DefaultBulkRetireSize
+	}
+
+	results := make([]*KillResult, 0, len(hosts))
+
+	for start := 0; start < len(hosts); start += size {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+
+		end := start + size
+		if end > len(hosts) {
+			end = len(hosts)
+		}
+		batch := hosts[start:end]
+
+		refused := make(map[string]error)
+		var allowed []*mackerel.Host
+		for _, host := range batch {
+			if err := m.CheckContext(ctx, host); err != nil {
+				if ctx.Err() != nil {
+					return results, ctx.Err()
+				}
+				refused[host.ID] = err
+				continue
+			}
+			allowed = append(allowed, host)
+		}
+
+		bulk := len(allowed) > 1 && m.bulkRetire(allowed) == nil
+
+		for _, host := range batch {
+			if err, ok := refused[host.ID]; ok {
+				results = append(results, &KillResult{Host: host, Err: err})
+				continue
+			}
+
+			if bulk {
+				results = append(results, &KillResult{Host: host})
+				continue
+			}
+
+			if err := ctx.Err(); err != nil {
+				return results, err
+			}
+
+			results = append(results, &KillResult{Host: host, Err: m.Client.RetireHost(host.ID)})
+		}
+	}
+
+	return results, nil
+}
+
+// bulkRetire retires the hosts with a single request
+func (m *Mkk) bulkRetire(hosts []*mackerel.Host) error {
+	ids := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		ids = append(ids, host.ID)
+	}
+
+	resp, err := m.Client.PostJSON("/api/v0/hosts/bulk-retire", map[string][]string{"ids": ids})
+	if resp != nil {
+		resp.Body.Close()
+	}
+
+	return err
+}
@@ -1,5 +1,3 @@
-// +build integration
-
 package mkk
 
 import (
@@ -40,7 +38,7 @@ func TestMkk_Integration_FindHosts(t *testing.T) {
 	}
 
 	// Wait until the metric is available via the API
-	time.Sleep(20 * time.Second)
+	time.Sleep(metricDelay)
 
 	var cases = []struct {
 		title   string
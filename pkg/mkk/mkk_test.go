@@ -1,8 +1,11 @@
 package mkk
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"reflect"
 	"testing"
 
 	"github.com/shuheiktgw/mackerel-killer/test/until"
@@ -98,3 +101,157 @@ func TestMkk_FindHosts(t *testing.T) {
 		})
 	}
 }
+
+func TestMkk_KillContext(t *testing.T) {
+	m, mux, _, teardown := setup()
+	defer teardown()
+
+	id := "abcdefg"
+
+	var requests int
+	mux.HandleFunc(fmt.Sprintf("/api/v0/hosts/%s/retire", id), func(w http.ResponseWriter, r *http.Request) {
+		util.TestMethod(t, r, http.MethodPost)
+		requests++
+		fmt.Fprint(w, `{"success": true}`)
+	})
+
+	host := &mackerel.Host{ID: id}
+
+	if err := m.KillContext(context.Background(), host); err != nil {
+		t.Errorf("Mkk.KillContext returned error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if got, want := m.KillContext(ctx, host), context.Canceled; got != want {
+		t.Errorf("invalid error: got: %v, want: %v", got, want)
+	}
+
+	if got, want := requests, 1; got != want {
+		t.Errorf("invalid number of requests: got: %v, want: %v", got, want)
+	}
+}
+
+func TestMkk_KillAll(t *testing.T) {
+	m, mux, _, teardown := setup()
+	defer teardown()
+
+	m.BulkRetireSize = 2
+	m.Retry.MaxAttempts = 1
+
+	// The batch containing c fails and d cannot be retired even one by one
+	var bulkRequests, singleRequests int
+	mux.HandleFunc("/api/v0/hosts/bulk-retire", func(w http.ResponseWriter, r *http.Request) {
+		util.TestMethod(t, r, http.MethodPost)
+		bulkRequests++
+
+		var body struct {
+			IDs []string `json:"ids"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+
+		for _, id := range body.IDs {
+			if id == "c" {
+				w.WriteHeader(http.StatusInternalServerError)
+				break
+			}
+		}
+		fmt.Fprint(w, `{"success": true}`)
+	})
+
+	mux.HandleFunc("/api/v0/hosts/", func(w http.ResponseWriter, r *http.Request) {
+		util.TestMethod(t, r, http.MethodPost)
+		singleRequests++
+
+		if r.URL.Path == "/api/v0/hosts/d/retire" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		fmt.Fprint(w, `{"success": true}`)
+	})
+
+	var hosts []*mackerel.Host
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		hosts = append(hosts, &mackerel.Host{ID: id})
+	}
+
+	results := m.KillAll(hosts)
+
+	if got, want := len(results), len(hosts); got != want {
+		t.Fatalf("invalid number of results: got: %v, want: %v", got, want)
+	}
+
+	for i, r := range results {
+		if got, want := r.Host, hosts[i]; got != want {
+			t.Errorf("#%d invalid host: got: %v, want: %v", i, got.ID, want.ID)
+		}
+
+		if got, want := r.Err != nil, r.Host.ID == "d"; got != want {
+			t.Errorf("#%d invalid error: got: %v", i, r.Err)
+		}
+	}
+
+	// a and b are retired in bulk, c and d are retired one by one and e alone
+	if got, want := bulkRequests, 2; got != want {
+		t.Errorf("invalid number of bulk requests: got: %v, want: %v", got, want)
+	}
+
+	if got, want := singleRequests, 3; got != want {
+		t.Errorf("invalid number of single requests: got: %v, want: %v", got, want)
+	}
+}
+
+func TestMkk_KillAll_Working(t *testing.T) {
+	var cases = []struct {
+		title        string
+		allowWorking bool
+		want         []string
+	}{
+		{
+			title:        "Working host is refused",
+			allowWorking: false,
+			want:         []string{"b", "c"},
+		},
+		{
+			title:        "Working host is allowed",
+			allowWorking: true,
+			want:         []string{"a", "b", "c"},
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(tc.title, func(t *testing.T) {
+			m, mux, _, teardown := setup()
+			defer teardown()
+
+			m.AllowWorking = tc.allowWorking
+
+			var retired []string
+			mux.HandleFunc("/api/v0/hosts/bulk-retire", func(w http.ResponseWriter, r *http.Request) {
+				var body struct {
+					IDs []string `json:"ids"`
+				}
+				json.NewDecoder(r.Body).Decode(&body)
+
+				retired = append(retired, body.IDs...)
+				fmt.Fprint(w, `{"success": true}`)
+			})
+
+			hosts := []*mackerel.Host{
+				{ID: "a", Status: mackerel.HostStatusWorking},
+				{ID: "b", Status: mackerel.HostStatusStandby},
+				{ID: "c", Status: mackerel.HostStatusPoweroff},
+			}
+
+			results := m.KillAll(hosts)
+
+			if got, want := retired, tc.want; !reflect.DeepEqual(got, want) {
+				t.Errorf("#%d invalid retired hosts: got: %v, want: %v", i, got, want)
+			}
+
+			if got, want := IsRefused(results[0].Err), !tc.allowWorking; got != want {
+				t.Errorf("#%d invalid error of the working host: got: %v", i, results[0].Err)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package mkk
+
+import (
+	"context"
+	"net"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+// InterfaceFilter selects hosts by the IP addresses of their network interfaces
+// Names are glob patterns of the interface names such as eth* and all the interfaces are used when empty
+// A host is selected when any of the addresses is in Include CIDRs and none of them is in Exclude CIDRs
+// At least one of Include and Exclude is required, and every host is included when Include is empty
+type InterfaceFilter struct {
+	Names   []string
+	Include []string
+	Exclude []string
+}
+
+// Validate validates the interface name patterns and CIDRs of InterfaceFilter
+func (f *InterfaceFilter) Validate() error {
+	for _, pattern := range f.Names {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Wrapf(err, "InterfaceFilter: invalid name pattern %q", pattern)
+		}
+	}
+
+	if len(f.Include) == 0 && len(f.Exclude) == 0 {
+		return errors.New("InterfaceFilter: missing include or exclude")
+	}
+
+	if _, err := parseCIDRs(f.Include); err != nil {
+		return errors.Wrap(err, "InterfaceFilter: invalid include")
+	}
+	if _, err := parseCIDRs(f.Exclude); err != nil {
+		return errors.Wrap(err, "InterfaceFilter: invalid exclude")
+	}
+
+	return nil
+}
+
+// Apply applies InterfaceFilter to the given hosts
+func (f *InterfaceFilter) Apply(m *mackerel.Client, hosts []*mackerel.Host) ([]*mackerel.Host, error) {
+	return applyExplainer(context.Background(), f, m, hosts)
+}
+
+// ApplyContext applies InterfaceFilter to the given hosts with the context
+func (f *InterfaceFilter) ApplyContext(ctx context.Context, m *mackerel.Client, hosts []*mackerel.Host) ([]*mackerel.Host, error) {
+	return applyExplainer(ctx, f, m, hosts)
+}
+
+// Explain explains InterfaceFilter on the given hosts
+func (f *InterfaceFilter) Explain(_ context.Context, _ *mackerel.Client, hosts []*mackerel.Host) ([]*Verdict, error) {
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+
+	include, _ := parseCIDRs(f.Include)
+	exclude, _ := parseCIDRs(f.Exclude)
+
+	verdicts := make([]*Verdict, 0, len(hosts))
+	for _, host := range hosts {
+		addrs := f.addresses(host)
+
+		if name, ip, n := matchCIDR(addrs, exclude); n != nil {
+			verdicts = append(verdicts, newVerdict(false, "%s address %s is in excluded %s", name, ip, n))
+			continue
+		}
+
+		if len(include) == 0 {
+			verdicts = append(verdicts, newVerdict(true, "no include CIDRs given and no exclude CIDRs match"))
+			continue
+		}
+
+		if name, ip, n := matchCIDR(addrs, include); n != nil {
+			verdicts = append(verdicts, newVerdict(true, "%s address %s is in %s", name, ip, n))
+		} else if len(addrs) == 0 {
+			verdicts = append(verdicts, newVerdict(false, "has no addresses on the interfaces"))
+		} else {
+			verdicts = append(verdicts, newVerdict(false, "none of %d addresses is in %s", len(addrs), strings.Join(f.Include, ", ")))
+		}
+	}
+
+	return verdicts, nil
+}
+
+// interfaceAddress is an IP address of a network interface
+type interfaceAddress struct {
+	name string
+	ip   net.IP
+}
+
+// addresses returns the addresses of the interfaces whose names match Names
+func (f *InterfaceFilter) addresses(host *mackerel.Host) []*interfaceAddress {
+	var addrs []*interfaceAddress
+
+	for _, iface := range host.Interfaces {
+		if len(f.Names) > 0 && !matchInterfaceName(iface.Name, f.Names) {
+			continue
+		}
+
+		raw := append([]string{iface.IPAddress}, iface.IPv4Addresses...)
+		raw = append(raw, iface.IPv6Addresses...)
+
+		for _, s := range raw {
+			if ip := parseIP(s); ip != nil {
+				addrs = append(addrs, &interfaceAddress{name: iface.Name, ip: ip})
+			}
+		}
+	}
+
+	return addrs
+}
+
+func matchInterfaceName(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseIP parses an IP address which may have a prefix length like 10.0.0.1/24
+func parseIP(s string) net.IP {
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		s = s[:i]
+	}
+
+	return net.ParseIP(s)
+}
+
+// matchCIDR returns the first address in any of the networks
+func matchCIDR(addrs []*interfaceAddress, networks []*net.IPNet) (string, net.IP, *net.IPNet) {
+	for _, addr := range addrs {
+		for _, n := range networks {
+			if n.Contains(addr.ip) {
+				return addr.name, addr.ip, n
+			}
+		}
+	}
+
+	return "", nil, nil
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, n)
+	}
+
+	return networks, nil
+}
@@ -0,0 +1,84 @@
+package mkk
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+func TestInterfaceFilter_Apply(t *testing.T) {
+	var cases = []struct {
+		title  string
+		filter InterfaceFilter
+		want   []string
+	}{
+		{
+			title:  "Include CIDR",
+			filter: InterfaceFilter{Include: []string{"10.20.0.0/16"}},
+			want:   []string{"1", "2"},
+		},
+		{
+			title:  "Include IPv6 CIDR",
+			filter: InterfaceFilter{Include: []string{"fd00::/8"}},
+			want:   []string{"3"},
+		},
+		{
+			title:  "Interface name",
+			filter: InterfaceFilter{Names: []string{"eth*"}, Include: []string{"10.20.0.0/16"}},
+			want:   []string{"1"},
+		},
+		{
+			title:  "Exclude CIDR",
+			filter: InterfaceFilter{Include: []string{"10.0.0.0/8"}, Exclude: []string{"10.20.1.0/24"}},
+			want:   []string{"1", "3"},
+		},
+	}
+
+	hosts := []*mackerel.Host{
+		{ID: "1", Interfaces: []mackerel.Interface{{Name: "eth0", IPv4Addresses: []string{"10.20.0.5"}}}},
+		{ID: "2", Interfaces: []mackerel.Interface{{Name: "docker0", IPAddress: "10.20.1.1"}}},
+		{ID: "3", Interfaces: []mackerel.Interface{{Name: "ens5", IPv4Addresses: []string{"10.30.0.5"}, IPv6Addresses: []string{"fd00::1"}}}},
+		{ID: "4"},
+	}
+
+	for i, tc := range cases {
+		client := mackerel.Client{}
+
+		t.Run(tc.title, func(t *testing.T) {
+			filtered, err := tc.filter.Apply(&client, hosts)
+			if err != nil {
+				t.Fatalf("#%d InterfaceFilter.Apply returned error: %v", i, err)
+			}
+
+			var got []string
+			for _, h := range filtered {
+				got = append(got, h.ID)
+			}
+
+			if want := tc.want; !reflect.DeepEqual(got, want) {
+				t.Errorf("#%d invalid hosts: got: %v, want: %v", i, got, want)
+			}
+		})
+	}
+}
+
+func TestInterfaceFilter_Validate(t *testing.T) {
+	cases := []struct {
+		params string
+		error  bool
+	}{
+		{params: `{"include":["10.20.0.0"]}`, error: true},
+		{params: `{}`, error: true},
+		{params: `{"names":["eth*"]}`, error: true},
+		{params: `{"include":["10.20.0.0/16"]}`, error: false},
+		{params: `{"exclude":["10.20.1.0/24"]}`, error: false},
+	}
+
+	for i, tc := range cases {
+		_, err := NewFilter("InterfaceFilter", []byte(tc.params))
+		if got, want := err != nil, tc.error; got != want {
+			t.Errorf("#%d invalid error: params: %s, got: %v, want: %v", i, tc.params, err, want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package mkk
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+// Plan is a list of hosts selected to retire
+// Plan is saved to a file so that it can be reviewed before retiring the hosts
+type Plan struct {
+	CreatedAt int64          `json:"createdAt"`
+	Hosts     []*PlannedHost `json:"hosts"`
+}
+
+// PlannedHost is a host in Plan along with its state when the plan is made
+// and the reasons why it is selected
+type PlannedHost struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`
+	Status    string   `json:"status"`
+	Roles     []string `json:"roles"`
+	CreatedAt int32    `json:"createdAt"`
+	Reasons   []string `json:"reasons"`
+}
+
+// PlanMismatchError is returned when a planned host has changed since the plan is made
+type PlanMismatchError struct {
+	ID      string
+	Name    string
+	Field   string
+	Planned interface{}
+	Current interface{}
+}
+
+func (e *PlanMismatchError) Error() string {
+	return fmt.Sprintf("host has changed since the plan is made: id: %v, name: %v, %s: planned: %v, current: %v", e.ID, e.Name, e.Field, e.Planned, e.Current)
+}
+
+// NewPlan makes Plan of the hosts selected in the given traces
+func NewPlan(traces []*Trace) *Plan {
+	p := Plan{CreatedAt: time.Now().Unix(), Hosts: []*PlannedHost{}}
+	for _, t := range traces {
+		if !t.Selected {
+			continue
+		}
+
+		p.Hosts = append(p.Hosts, &PlannedHost{
+			ID:        t.Host.ID,
+			Name:      t.Host.Name,
+			Type:      t.Host.Type,
+			Status:    t.Host.Status,
+			Roles:     roleFullnames(t.Host),
+			CreatedAt: t.Host.CreatedAt,
+			Reasons:   t.Reasons(),
+		})
+	}
+
+	return &p
+}
+
+// Verify fetches the planned host and checks that it still exists and has not changed since the plan is made
+// It returns *PlanMismatchError when the host has changed
+func (m *Mkk) Verify(planned *PlannedHost) (*mackerel.Host, error) {
+	host, err := m.Client.FindHost(planned.ID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Mkk.Verify fails while finding a host: id: %v, name: %v", planned.ID, planned.Name)
+	}
+
+	mismatch := func(field string, planned, current interface{}) error {
+		return &PlanMismatchError{ID: host.ID, Name: host.Name, Field: field, Planned: planned, Current: current}
+	}
+
+	if host.IsRetired {
+		return nil, mismatch("isRetired", false, true)
+	}
+
+	if host.Name != planned.Name {
+		return nil, mismatch("name", planned.Name, host.Name)
+	}
+
+	if host.Type != planned.Type {
+		return nil, mismatch("type", planned.Type, host.Type)
+	}
+
+	if host.Status != planned.Status {
+		return nil, mismatch("status", planned.Status, host.Status)
+	}
+
+	if roles := roleFullnames(host); !reflect.DeepEqual(roles, planned.Roles) {
+		return nil, mismatch("roles", planned.Roles, roles)
+	}
+
+	return host, nil
+}
+
+// roleFullnames returns the sorted role fullnames of the host, never nil
+func roleFullnames(host *mackerel.Host) []string {
+	roles := append([]string{}, host.GetRoleFullnames()...)
+	sort.Strings(roles)
+
+	return roles
+}
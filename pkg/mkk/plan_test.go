@@ -0,0 +1,126 @@
+package mkk
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/shuheiktgw/mackerel-killer/test/until"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+func TestNewPlan(t *testing.T) {
+	host := &mackerel.Host{
+		ID:     "abcdefg",
+		Name:   "mackerel-killer-host",
+		Type:   "unknown",
+		Status: "working",
+		Roles:  mackerel.Roles{"service": []string{"web", "db"}},
+	}
+
+	dropped := &mackerel.Host{ID: "hijklmn", Type: "agent"}
+
+	m := NewMkk("")
+	traces, err := m.Evaluate([]*mackerel.Host{host, dropped}, []Filter{&HostFilter{Type: "unknown"}})
+	if err != nil {
+		t.Fatalf("Mkk.Evaluate returned error: %v", err)
+	}
+
+	p := NewPlan(traces)
+
+	want := []*PlannedHost{
+		{
+			ID:      "abcdefg",
+			Name:    "mackerel-killer-host",
+			Type:    "unknown",
+			Status:  "working",
+			Roles:   []string{"service:db", "service:web"},
+			Reasons: []string{`HostFilter{Type:unknown}: type is "unknown"`},
+		},
+	}
+
+	if got := p.Hosts; !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid planned hosts: got: %v, want: %v", got, want)
+	}
+}
+
+func TestMkk_Verify(t *testing.T) {
+	id := "abcdefg"
+	planned := &PlannedHost{ID: id, Name: "mackerel-killer-host", Type: "unknown", Status: "standby", Roles: []string{"service:web"}}
+
+	var cases = []struct {
+		title    string
+		status   int
+		response string
+		error    bool
+		mismatch bool
+	}{
+		{
+			title:    "Host has not changed",
+			status:   http.StatusOK,
+			response: `{"host": {"id":"abcdefg","name":"mackerel-killer-host","type":"unknown","status":"standby","roles":{"service":["web"]}}}`,
+		},
+		{
+			title:    "Host does not exist",
+			status:   http.StatusNotFound,
+			response: `{"error": {"message": "Host not found"}}`,
+			error:    true,
+		},
+		{
+			title:    "Host has been retired",
+			status:   http.StatusOK,
+			response: `{"host": {"id":"abcdefg","name":"mackerel-killer-host","type":"unknown","status":"standby","roles":{"service":["web"]},"isRetired":true}}`,
+			error:    true,
+			mismatch: true,
+		},
+		{
+			title:    "Status has changed",
+			status:   http.StatusOK,
+			response: `{"host": {"id":"abcdefg","name":"mackerel-killer-host","type":"unknown","status":"working","roles":{"service":["web"]}}}`,
+			error:    true,
+			mismatch: true,
+		},
+		{
+			title:    "Roles have changed",
+			status:   http.StatusOK,
+			response: `{"host": {"id":"abcdefg","name":"mackerel-killer-host","type":"unknown","status":"standby","roles":{"service":["web","db"]}}}`,
+			error:    true,
+			mismatch: true,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(tc.title, func(t *testing.T) {
+			m, mux, _, teardown := setup()
+			defer teardown()
+
+			mux.HandleFunc(fmt.Sprintf("/api/v0/hosts/%s", id), func(w http.ResponseWriter, r *http.Request) {
+				util.TestMethod(t, r, http.MethodGet)
+				w.WriteHeader(tc.status)
+				fmt.Fprint(w, tc.response)
+			})
+
+			host, err := m.Verify(planned)
+
+			if tc.error {
+				if err == nil {
+					t.Fatalf("#%d error is not supposed to be nil", i)
+				}
+
+				if _, ok := err.(*PlanMismatchError); ok != tc.mismatch {
+					t.Errorf("#%d invalid error type: got: %T", i, err)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("#%d Mkk.Verify returned error: %v", i, err)
+				}
+
+				if got, want := host.ID, id; got != want {
+					t.Errorf("#%d invalid host: got: %v, want: %v", i, got, want)
+				}
+			}
+		})
+	}
+}
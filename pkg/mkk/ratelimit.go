@@ -0,0 +1,111 @@
+package mkk
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits the requests sent to Mackerel by everything sharing it
+// such as all the filters and jobs run by a Mkk, which is safe for concurrent use
+type RateLimiter struct {
+	bucket *tokenBucket
+}
+
+// NewRateLimiter initializes RateLimiter allowing requestsPerSecond with bursts of up to burst requests
+// It never blocks when requestsPerSecond is not positive
+func NewRateLimiter(requestsPerSecond float64, burst int) *RateLimiter {
+	return &RateLimiter{bucket: newTokenBucket(requestsPerSecond, burst)}
+}
+
+// Wait blocks until a request can be sent, where nil RateLimiter never blocks
+// It returns the error of the context when the context is done before that
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return ctx.Err()
+	}
+
+	return l.bucket.Wait(ctx)
+}
+
+type rateLimiterKey struct{}
+
+// WithRateLimiter returns a copy of the context which carries the rate limiter shared by filters
+func WithRateLimiter(ctx context.Context, l *RateLimiter) context.Context {
+	return context.WithValue(ctx, rateLimiterKey{}, l)
+}
+
+// rateLimiter returns the rate limiter in the context, which is nil when the context does not carry one
+func rateLimiter(ctx context.Context) *RateLimiter {
+	l, _ := ctx.Value(rateLimiterKey{}).(*RateLimiter)
+	return l
+}
+
+// withRateLimiter returns the context carrying Mkk.RateLimiter unless it is nil
+func (m *Mkk) withRateLimiter(ctx context.Context) context.Context {
+	if m.RateLimiter == nil {
+		return ctx
+	}
+
+	return WithRateLimiter(ctx, m.RateLimiter)
+}
+
+// tokenBucket is a token bucket rate limiter which is safe for concurrent use
+// Tokens are refilled at rate per second up to burst
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket initializes tokenBucket which starts with a full bucket
+// It returns nil, which never blocks, when rate is not positive
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Wait blocks until a token is available and takes it
+// It returns the error of the context when the context is done before that
+func (b *tokenBucket) Wait(ctx context.Context) error {
+	if b == nil {
+		return ctx.Err()
+	}
+
+	for {
+		b.mu.Lock()
+
+		now := time.Now()
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return nil
+		}
+
+		wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
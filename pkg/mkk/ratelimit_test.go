@@ -0,0 +1,74 @@
+package mkk
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+func TestTokenBucket_Wait(t *testing.T) {
+	b := newTokenBucket(100, 1)
+
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		b.Wait(context.Background())
+	}
+
+	// The first token is available immediately and the rest are refilled every 10ms
+	if got, want := time.Since(start), 50*time.Millisecond; got < want {
+		t.Errorf("tokens are taken too fast: got: %v, want: >= %v", got, want)
+	}
+}
+
+func TestTokenBucket_Wait_Unlimited(t *testing.T) {
+	var b *tokenBucket
+
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		b.Wait(context.Background())
+	}
+
+	if got, want := time.Since(start), 10*time.Millisecond; got > want {
+		t.Errorf("unlimited bucket is not supposed to block: got: %v", got)
+	}
+}
+
+func TestMkk_Filter_SharedRateLimiter(t *testing.T) {
+	m, mux, _, teardown := setup()
+	defer teardown()
+
+	m.RateLimiter = NewRateLimiter(50, 1)
+
+	var requests int32
+	mux.HandleFunc("/api/v0/hosts/", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		fmt.Fprint(w, `{"metrics": []}`)
+	})
+
+	hosts := []*mackerel.Host{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+
+	// Neither filter has a limiter of its own, so all the requests share the one of Mkk
+	filters := []Filter{
+		&AnyOf{Filters: []Filter{&HostFilter{Type: "none"}, &MetricAbsenceFilter{Name: "a", To: 100, RequestsPerSecond: -1}}},
+		&MetricAbsenceFilter{Name: "b", To: 100, RequestsPerSecond: -1},
+	}
+
+	start := time.Now()
+	if _, err := m.Filter(hosts, filters); err != nil {
+		t.Fatalf("Mkk.Filter returned error: %v", err)
+	}
+
+	if got, want := atomic.LoadInt32(&requests), int32(6); got != want {
+		t.Fatalf("invalid number of requests: got: %v, want: %v", got, want)
+	}
+
+	// The first request is sent immediately and the rest are sent every 20ms
+	if got, want := time.Since(start), 100*time.Millisecond; got < want {
+		t.Errorf("requests are sent too fast: got: %v, want: >= %v", got, want)
+	}
+}
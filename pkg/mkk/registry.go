@@ -0,0 +1,180 @@
+package mkk
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// FilterFactory builds a Filter from its params in JSON
+// params may be empty when no params are given
+type FilterFactory func(params json.RawMessage) (Filter, error)
+
+// FilterSpec describes a filter in JSON, e.g. {"type":"HostFilter","params":{"type":"agent"}}
+type FilterSpec struct {
+	Type   string          `json:"type"`
+	Params json.RawMessage `json:"params"`
+}
+
+// Validator is implemented by filters which validate their params when built by JSONFilterFactory
+type Validator interface {
+	Validate() error
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]FilterFactory)
+)
+
+func init() {
+	RegisterFilter("GracePeriodFilter", JSONFilterFactory(func() Filter { return &GracePeriodFilter{} }))
+	RegisterFilter("HostFilter", JSONFilterFactory(func() Filter { return &HostFilter{} }))
+	RegisterFilter("MetricAbsenceFilter", JSONFilterFactory(func() Filter { return &MetricAbsenceFilter{} }))
+	RegisterFilter("LatestMetricStaleFilter", JSONFilterFactory(func() Filter { return &LatestMetricStaleFilter{} }))
+	RegisterFilter("MetricThresholdFilter", JSONFilterFactory(func() Filter { return &MetricThresholdFilter{} }))
+	RegisterFilter("NameFilter", JSONFilterFactory(func() Filter { return &NameFilter{} }))
+	RegisterFilter("RoleFilter", JSONFilterFactory(func() Filter { return &RoleFilter{} }))
+	RegisterFilter("StatusFilter", JSONFilterFactory(func() Filter { return &StatusFilter{} }))
+	RegisterFilter("MetadataFilter", JSONFilterFactory(func() Filter { return &MetadataFilter{} }))
+	RegisterFilter("InterfaceFilter", JSONFilterFactory(func() Filter { return &InterfaceFilter{} }))
+
+	RegisterFilter("AllOf", newAllOf)
+	RegisterFilter("AnyOf", newAnyOf)
+	RegisterFilter("Not", newNot)
+}
+
+// RegisterFilter makes a filter available by the given name
+// It panics if the factory is nil or the name is already registered
+func RegisterFilter(name string, factory FilterFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if factory == nil {
+		panic("mkk: RegisterFilter factory is nil")
+	}
+
+	if _, dup := registry[name]; dup {
+		panic("mkk: RegisterFilter called twice for filter " + name)
+	}
+
+	registry[name] = factory
+}
+
+// RegisteredFilters returns the sorted names of the registered filters
+func RegisteredFilters() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// JSONFilterFactory returns a FilterFactory which unmarshals params into the filter returned by newFilter
+// and validates it when the filter implements Validator
+// Params with a field unknown to the filter are rejected so that a typo does not widen the filter silently
+func JSONFilterFactory(newFilter func() Filter) FilterFactory {
+	return func(params json.RawMessage) (Filter, error) {
+		f := newFilter()
+
+		if len(params) != 0 {
+			if err := decodeStrict(params, f); err != nil {
+				return nil, err
+			}
+		}
+
+		if v, ok := f.(Validator); ok {
+			if err := v.Validate(); err != nil {
+				return nil, err
+			}
+		}
+
+		return f, nil
+	}
+}
+
+// decodeStrict is json.Unmarshal which rejects unknown fields
+// Filters with UnmarshalJSON use it as well since the option of a Decoder is not passed to them
+func decodeStrict(b []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+
+	return dec.Decode(v)
+}
+
+// NewFilter builds the filter registered by the given name
+func NewFilter(name string, params json.RawMessage) (Filter, error) {
+	registryMu.RLock()
+	factory, ok := registry[name]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("filter named `%s` does not exist", name)
+	}
+
+	return factory(params)
+}
+
+// NewFilters builds filters from the given specs keeping their order
+func NewFilters(specs []FilterSpec) ([]Filter, error) {
+	fs := make([]Filter, 0, len(specs))
+	for i, spec := range specs {
+		f, err := NewFilter(spec.Type, spec.Params)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error occurred while building %dth filter", i)
+		}
+
+		fs = append(fs, f)
+	}
+
+	return fs, nil
+}
+
+func newAllOf(params json.RawMessage) (Filter, error) {
+	children, err := newChildFilters(params)
+	if err != nil {
+		return nil, errors.Wrap(err, "error occurred while building children of AllOf")
+	}
+
+	return &AllOf{Filters: children}, nil
+}
+
+func newAnyOf(params json.RawMessage) (Filter, error) {
+	children, err := newChildFilters(params)
+	if err != nil {
+		return nil, errors.Wrap(err, "error occurred while building children of AnyOf")
+	}
+
+	return &AnyOf{Filters: children}, nil
+}
+
+func newNot(params json.RawMessage) (Filter, error) {
+	var spec FilterSpec
+	if err := json.Unmarshal(params, &spec); err != nil {
+		return nil, err
+	}
+
+	child, err := NewFilter(spec.Type, spec.Params)
+	if err != nil {
+		return nil, errors.Wrap(err, "error occurred while building a child of Not")
+	}
+
+	return &Not{Filter: child}, nil
+}
+
+func newChildFilters(params json.RawMessage) ([]Filter, error) {
+	var specs []FilterSpec
+	if err := json.Unmarshal(params, &specs); err != nil {
+		return nil, err
+	}
+
+	return NewFilters(specs)
+}
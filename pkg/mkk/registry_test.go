@@ -0,0 +1,77 @@
+package mkk
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+type nopFilter struct {
+	Label string
+}
+
+func (f *nopFilter) Apply(_ *mackerel.Client, hosts []*mackerel.Host) ([]*mackerel.Host, error) {
+	return hosts, nil
+}
+
+func TestRegisterFilter(t *testing.T) {
+	RegisterFilter("testNopFilter", JSONFilterFactory(func() Filter { return &nopFilter{} }))
+
+	var found bool
+	for _, name := range RegisteredFilters() {
+		if name == "testNopFilter" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("RegisteredFilters does not contain testNopFilter: %v", RegisteredFilters())
+	}
+
+	var specs []FilterSpec
+	raw := `[{"type":"testNopFilter","params":{"label":"nop"}},{"type":"Not","params":{"type":"testNopFilter"}}]`
+	if err := json.Unmarshal([]byte(raw), &specs); err != nil {
+		t.Fatalf("error occurred while unmarshaling specs: %v", err)
+	}
+
+	fs, err := NewFilters(specs)
+	if err != nil {
+		t.Fatalf("NewFilters returned error: %v", err)
+	}
+
+	want := []Filter{&nopFilter{Label: "nop"}, &Not{Filter: &nopFilter{}}}
+	if got := fs; !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid filters: got: %v, want: %v", got, want)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("RegisterFilter is supposed to panic when the name is registered twice")
+		}
+	}()
+	RegisterFilter("testNopFilter", JSONFilterFactory(func() Filter { return &nopFilter{} }))
+}
+
+func TestNewFilter_Unknown(t *testing.T) {
+	if _, err := NewFilter("UnknownFilter", nil); err == nil {
+		t.Errorf("NewFilter is supposed to return error for an unknown filter")
+	}
+}
+
+func TestNewFilter_UnknownField(t *testing.T) {
+	cases := []struct {
+		name   string
+		params string
+	}{
+		{name: "HostFilter", params: `{"type":"unknown","typo":true}`},
+		{name: "MetricAbsenceFilter", params: `{"name":"loadavg5","form":155891000}`},
+		{name: "MetricThresholdFilter", params: `{"name":"loadavg5","from":1,"to":100,"treshold":1}`},
+	}
+
+	for i, tc := range cases {
+		if _, err := NewFilter(tc.name, []byte(tc.params)); err == nil {
+			t.Errorf("#%d NewFilter is supposed to return error for an unknown field: %s", i, tc.params)
+		}
+	}
+}
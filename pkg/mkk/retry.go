@@ -0,0 +1,210 @@
+package mkk
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultMaxAttempts is the default number of attempts of a request including the first one
+	DefaultMaxAttempts = 5
+
+	// DefaultBaseDelay is the default delay before the first retry
+	DefaultBaseDelay = 500 * time.Millisecond
+
+	// DefaultMaxDelay is the default upper limit of a delay between attempts
+	DefaultMaxDelay = 30 * time.Second
+
+	// DefaultAttemptTimeout is the default time limit of each attempt
+	DefaultAttemptTimeout = 30 * time.Second
+)
+
+// RetryTransport is a http.RoundTripper which retries requests failed
+// with network errors, 429 Too Many Requests or 5xx responses
+// It waits with jittered exponential backoff between attempts,
+// or for the duration of Retry-After header when the response has one
+type RetryTransport struct {
+	// Base is the underlying http.RoundTripper, http.DefaultTransport is used when nil
+	Base http.RoundTripper
+
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+
+	// Timeout limits each attempt until its response body is closed, which is disabled when zero
+	// The timeout of http.Client should be disabled as it covers all the attempts and the delays between them
+	Timeout time.Duration
+
+	// Logf is called on every retry when it is not nil
+	Logf func(format string, args ...interface{})
+
+	// Cancel stops waiting for the next attempt when it is closed, such as the Done channel of a context
+	// mackerel.Client sends requests without the context given to Mkk, so the request context alone never stops retries
+	Cancel <-chan struct{}
+
+	retries int64
+}
+
+// NewRetryTransport initializes RetryTransport with the default settings
+func NewRetryTransport(base http.RoundTripper) *RetryTransport {
+	return &RetryTransport{
+		Base:        base,
+		MaxAttempts: DefaultMaxAttempts,
+		BaseDelay:   DefaultBaseDelay,
+		MaxDelay:    DefaultMaxDelay,
+		Timeout:     DefaultAttemptTimeout,
+	}
+}
+
+// Retries returns the total number of retries made by RetryTransport
+func (t *RetryTransport) Retries() int64 {
+	return atomic.LoadInt64(&t.retries)
+}
+
+// RoundTrip sends the request and retries it when it fails transiently
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	for attempt := 1; ; attempt++ {
+		r := req
+		if attempt > 1 && req.Body != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+
+			copied := *req
+			copied.Body = body
+			r = &copied
+		}
+
+		cancel := func() {}
+		if t.Timeout > 0 {
+			ctx, c := context.WithTimeout(req.Context(), t.Timeout)
+			r, cancel = r.WithContext(ctx), c
+		}
+
+		resp, err := base.RoundTrip(r)
+
+		if attempt >= t.MaxAttempts || !retryable(req, resp, err) {
+			if resp == nil {
+				cancel()
+			} else {
+				resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+			}
+			return resp, err
+		}
+
+		delay := t.backoff(attempt)
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = resp.Status
+			if after, ok := retryAfter(resp); ok {
+				delay = after
+				if delay > t.MaxDelay {
+					delay = t.MaxDelay
+				}
+			}
+
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		cancel()
+
+		atomic.AddInt64(&t.retries, 1)
+		if t.Logf != nil {
+			t.Logf("Retrying %s %s in %v (attempt %d/%d): %s", req.Method, req.URL.Path, delay, attempt+1, t.MaxAttempts, reason)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-t.Cancel:
+			timer.Stop()
+			return nil, context.Canceled
+		case <-timer.C:
+		}
+	}
+}
+
+// cancelBody cancels the context of the attempt when the response body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// backoff returns a jittered exponential delay before the next attempt
+func (t *RetryTransport) backoff(attempt int) time.Duration {
+	delay := t.BaseDelay
+	for i := 1; i < attempt && delay < t.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > t.MaxDelay {
+		delay = t.MaxDelay
+	}
+
+	if delay <= 0 {
+		return 0
+	}
+
+	// Full jitter within the upper half of the delay
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// retryable reports whether the request can be sent again
+func retryable(req *http.Request, resp *http.Response, err error) bool {
+	if req.Body != nil && req.GetBody == nil {
+		return false
+	}
+
+	if err != nil {
+		return req.Context().Err() == nil
+	}
+
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// retryAfter parses Retry-After header given either in seconds or in HTTP date
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(v); err == nil {
+		if seconds < 0 {
+			seconds = 0
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,225 @@
+package mkk
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shuheiktgw/mackerel-killer/test/until"
+)
+
+func TestRetryTransport_RoundTrip(t *testing.T) {
+	var cases = []struct {
+		title       string
+		statuses    []int
+		retryAfter  string
+		maxAttempts int
+		error       bool
+		attempts    int32
+	}{
+		{
+			title:       "Succeeds at first",
+			statuses:    []int{http.StatusOK},
+			maxAttempts: 3,
+			attempts:    1,
+		},
+		{
+			title:       "Succeeds after 5xx",
+			statuses:    []int{http.StatusServiceUnavailable, http.StatusInternalServerError, http.StatusOK},
+			maxAttempts: 3,
+			attempts:    3,
+		},
+		{
+			title:       "Succeeds after 429 with Retry-After",
+			statuses:    []int{http.StatusTooManyRequests, http.StatusOK},
+			retryAfter:  "0",
+			maxAttempts: 3,
+			attempts:    2,
+		},
+		{
+			title:       "Gives up after max attempts",
+			statuses:    []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway},
+			maxAttempts: 2,
+			error:       true,
+			attempts:    2,
+		},
+		{
+			title:       "Does not retry 4xx",
+			statuses:    []int{http.StatusNotFound, http.StatusOK},
+			maxAttempts: 3,
+			error:       true,
+			attempts:    1,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(tc.title, func(t *testing.T) {
+			m, mux, _, teardown := setup()
+			defer teardown()
+
+			m.Retry.MaxAttempts = tc.maxAttempts
+
+			var logs int32
+			m.Retry.Logf = func(format string, args ...interface{}) {
+				atomic.AddInt32(&logs, 1)
+			}
+
+			id := "abcdefg"
+
+			var attempts int32
+			mux.HandleFunc(fmt.Sprintf("/api/v0/hosts/%s/retire", id), func(w http.ResponseWriter, r *http.Request) {
+				util.TestMethod(t, r, http.MethodPost)
+
+				// The body has to be sent on every attempt
+				if body, _ := ioutil.ReadAll(r.Body); len(body) == 0 {
+					t.Errorf("#%d request body is empty", i)
+				}
+
+				n := atomic.AddInt32(&attempts, 1)
+				if tc.retryAfter != "" {
+					w.Header().Set("Retry-After", tc.retryAfter)
+				}
+				w.WriteHeader(tc.statuses[n-1])
+				fmt.Fprint(w, `{}`)
+			})
+
+			err := m.Client.RetireHost(id)
+
+			if tc.error {
+				if err == nil {
+					t.Errorf("#%d error is not supposed to be nil", i)
+				}
+			} else if err != nil {
+				t.Errorf("#%d RetireHost returned error: %v", i, err)
+			}
+
+			if got, want := atomic.LoadInt32(&attempts), tc.attempts; got != want {
+				t.Errorf("#%d invalid number of attempts: got: %v, want: %v", i, got, want)
+			}
+
+			if got, want := m.Retry.Retries(), int64(tc.attempts-1); got != want {
+				t.Errorf("#%d invalid number of retries: got: %v, want: %v", i, got, want)
+			}
+
+			if got, want := atomic.LoadInt32(&logs), tc.attempts-1; got != want {
+				t.Errorf("#%d invalid number of logs: got: %v, want: %v", i, got, want)
+			}
+		})
+	}
+}
+
+func TestRetryTransport_RoundTrip_Cancel(t *testing.T) {
+	m, mux, _, teardown := setup()
+	defer teardown()
+
+	m.Retry.BaseDelay = time.Minute
+	m.Retry.MaxDelay = time.Minute
+
+	cancel := make(chan struct{})
+	m.Retry.Cancel = cancel
+
+	// Cancel while waiting for the second attempt
+	var attempts int32
+	mux.HandleFunc("/api/v0/hosts/abcdefg", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		close(cancel)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, `{}`)
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := m.Client.FindHost("abcdefg")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		// http.Client wraps the error of the transport in *url.Error
+		if err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+			t.Errorf("invalid error: got: %v, want: %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("RetryTransport kept waiting after Cancel is closed")
+	}
+
+	if got, want := atomic.LoadInt32(&attempts), int32(1); got != want {
+		t.Errorf("invalid number of attempts: got: %v, want: %v", got, want)
+	}
+}
+
+func TestRetryTransport_RoundTrip_RetryAfterBeyondTimeout(t *testing.T) {
+	m, mux, _, teardown := setup()
+	defer teardown()
+
+	if got := m.Client.HTTPClient.Timeout; got != 0 {
+		t.Fatalf("timeout of http.Client should be disabled: got: %v", got)
+	}
+
+	// Retry-After alone exceeds the time limit of an attempt
+	m.Retry.Timeout = 500 * time.Millisecond
+	m.Retry.MaxDelay = 2 * time.Second
+	m.Retry.MaxAttempts = 2
+
+	var attempts int32
+	mux.HandleFunc("/api/v0/hosts/abcdefg", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{}`)
+			return
+		}
+		fmt.Fprint(w, `{"host": {"id": "abcdefg"}}`)
+	})
+
+	start := time.Now()
+	host, err := m.Client.FindHost("abcdefg")
+	if err != nil {
+		t.Fatalf("error occurred while finding a host: %v", err)
+	}
+
+	if got, want := host.ID, "abcdefg"; got != want {
+		t.Errorf("invalid host: got: %v, want: %v", got, want)
+	}
+
+	if got, want := time.Since(start), time.Second; got < want {
+		t.Errorf("Retry-After is not respected: got: %v, want: >= %v", got, want)
+	}
+
+	if got, want := atomic.LoadInt32(&attempts), int32(2); got != want {
+		t.Errorf("invalid number of attempts: got: %v, want: %v", got, want)
+	}
+}
+
+func TestRetryTransport_RoundTrip_AttemptTimeout(t *testing.T) {
+	m, mux, _, teardown := setup()
+	defer teardown()
+
+	m.Retry.Timeout = 100 * time.Millisecond
+
+	// The first attempt hangs until the client gives up
+	var attempts int32
+	mux.HandleFunc("/api/v0/hosts/abcdefg", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		fmt.Fprint(w, `{"host": {"id": "abcdefg"}}`)
+	})
+
+	if _, err := m.Client.FindHost("abcdefg"); err != nil {
+		t.Fatalf("error occurred while finding a host: %v", err)
+	}
+
+	if got, want := atomic.LoadInt32(&attempts), int32(2); got != want {
+		t.Errorf("invalid number of attempts: got: %v, want: %v", got, want)
+	}
+}
@@ -0,0 +1,320 @@
+package mkk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+const (
+	hostsPath  = "/api/v0/hosts"
+	latestPath = "/api/v0/tsdb/latest"
+)
+
+// Snapshot is the hosts along with the API responses the filters read to evaluate them
+// Filters can be evaluated against a snapshot without sending any requests with NewMkkFromSnapshot
+// Latest holds the latest metric values by host IDs and metric names, where null means the host has no value
+// It serves requests for any subset of the hosts, not only the batches sent while taking the snapshot
+type Snapshot struct {
+	CreatedAt int64                       `json:"createdAt"`
+	Hosts     []*mackerel.Host            `json:"hosts"`
+	Responses []*RecordedResponse         `json:"responses"`
+	Latest    mackerel.LatestMetricValues `json:"latest,omitempty"`
+}
+
+// RecordedResponse is a response of the Mackerel API recorded in Snapshot
+// URL is the path and the query of the request, whose parameters are sorted by their names
+type RecordedResponse struct {
+	Method     string            `json:"method"`
+	URL        string            `json:"url"`
+	StatusCode int               `json:"statusCode"`
+	Header     map[string]string `json:"header,omitempty"`
+	Body       json.RawMessage   `json:"body,omitempty"`
+}
+
+// recordedHeaders are the response headers recorded in Snapshot
+var recordedHeaders = []string{"Last-Modified"}
+
+// TakeSnapshot evaluates every filter on all the hosts and records the responses the filters read
+// Unlike Evaluate, hosts dropped by a filter are passed to the following filters
+// so that other pipelines of the same filters can be evaluated against the snapshot
+// Relative times in the filters are resolved at the time of Clock,
+// which the Mkk returned by NewMkkFromSnapshot uses as well
+func (m *Mkk) TakeSnapshot(ctx context.Context, hosts []*mackerel.Host, filters []Filter) (*Snapshot, error) {
+	now := currentTime(m.withClock(ctx))
+	ctx = WithClock(m.withRateLimiter(ctx), FixedClock(now))
+
+	rec := &recorder{
+		base:      m.Client.HTTPClient.Transport,
+		responses: make(map[string]*RecordedResponse),
+		latest:    make(mackerel.LatestMetricValues),
+	}
+
+	client := *m.Client
+	client.HTTPClient = &http.Client{Transport: rec, Timeout: m.Client.HTTPClient.Timeout}
+
+	for _, f := range filters {
+		if _, err := explain(ctx, f, &client, hosts); err != nil {
+			return nil, errors.Wrap(err, "Mkk.TakeSnapshot fails while applying filters")
+		}
+	}
+
+	if m.MetadataNamespace != "" {
+		for _, host := range hosts {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			if _, err := getMetadata(ctx, &client, host.ID, m.MetadataNamespace); err != nil {
+				return nil, errors.Wrapf(err, "Mkk.TakeSnapshot fails while reading metadata: host: id: %v, name: %v", host.ID, host.Name)
+			}
+		}
+	}
+
+	s := Snapshot{CreatedAt: now.Unix(), Hosts: hosts, Responses: rec.recorded()}
+	if len(rec.latest) > 0 {
+		s.Latest = rec.latest
+	}
+
+	return &s, nil
+}
+
+// NewMkkFromSnapshot initializes Mkk which evaluates filters against the snapshot
+// Its Client serves the hosts in the snapshot and the recorded responses without sending any requests
+// and fails to retire hosts. Its Clock tells the time the snapshot was taken
+func NewMkkFromSnapshot(s *Snapshot) *Mkk {
+	m := NewMkk("")
+
+	m.Retry = NewRetryTransport(newReplayer(s))
+	m.Retry.MaxAttempts = 1
+	m.Client.HTTPClient.Transport = m.Retry
+
+	m.Clock = FixedClock(time.Unix(s.CreatedAt, 0))
+
+	// Nothing is sent to Mackerel while replaying
+	m.RateLimiter = nil
+
+	return m
+}
+
+// recorder is http.RoundTripper which records the responses of GET requests
+// The latest metric values are recorded by hosts instead of requests
+type recorder struct {
+	base http.RoundTripper
+
+	mu        sync.Mutex
+	responses map[string]*RecordedResponse
+	latest    mackerel.LatestMetricValues
+}
+
+func (r *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := r.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	resp, err := base.RoundTrip(req)
+	if err != nil || req.Method != http.MethodGet {
+		return resp, err
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+
+	if req.URL.Path == latestPath && resp.StatusCode == http.StatusOK {
+		return resp, r.recordLatest(req, b)
+	}
+
+	recorded := RecordedResponse{Method: req.Method, URL: requestURL(req), StatusCode: resp.StatusCode}
+
+	for _, name := range recordedHeaders {
+		if v := resp.Header.Get(name); v != "" {
+			if recorded.Header == nil {
+				recorded.Header = make(map[string]string)
+			}
+			recorded.Header[name] = v
+		}
+	}
+
+	if json.Valid(b) {
+		recorded.Body = b
+	}
+
+	r.mu.Lock()
+	r.responses[recorded.Method+" "+recorded.URL] = &recorded
+	r.mu.Unlock()
+
+	return resp, nil
+}
+
+// recordLatest records the latest values of every host and metric in the request,
+// including the ones without any values so that they are distinguished from the ones never requested
+func (r *recorder) recordLatest(req *http.Request, body []byte) error {
+	var data struct {
+		Latest mackerel.LatestMetricValues `json:"tsdbLatest"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return errors.Wrap(err, "error occurred while recording the latest metric values")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	q := req.URL.Query()
+	for _, id := range q["hostId"] {
+		if r.latest[id] == nil {
+			r.latest[id] = make(map[string]*mackerel.MetricValue)
+		}
+
+		for _, name := range q["name"] {
+			r.latest[id][name] = data.Latest[id][name]
+		}
+	}
+
+	return nil
+}
+
+// recorded returns the recorded responses sorted by their requests
+func (r *recorder) recorded() []*RecordedResponse {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys := make([]string, 0, len(r.responses))
+	for k := range r.responses {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	responses := make([]*RecordedResponse, 0, len(keys))
+	for _, k := range keys {
+		responses = append(responses, r.responses[k])
+	}
+
+	return responses
+}
+
+// replayer is http.RoundTripper which serves the hosts and the responses in Snapshot
+type replayer struct {
+	hosts     map[string]*mackerel.Host
+	snapshot  *Snapshot
+	responses map[string]*RecordedResponse
+}
+
+func newReplayer(s *Snapshot) *replayer {
+	r := replayer{
+		hosts:     make(map[string]*mackerel.Host),
+		snapshot:  s,
+		responses: make(map[string]*RecordedResponse),
+	}
+
+	for _, host := range s.Hosts {
+		r.hosts[host.ID] = host
+	}
+
+	for _, resp := range s.Responses {
+		r.responses[resp.Method+" "+resp.URL] = resp
+	}
+
+	return &r
+}
+
+func (r *replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return nil, errors.Errorf("%s %s cannot be sent while evaluating filters against a snapshot", req.Method, req.URL.Path)
+	}
+
+	// The hosts in the snapshot are served whatever the query parameters are
+	if req.URL.Path == hostsPath {
+		return r.serveJSON(req, http.StatusOK, map[string]interface{}{"hosts": r.snapshot.Hosts})
+	}
+
+	if req.URL.Path == latestPath {
+		return r.serveLatest(req)
+	}
+
+	if id := strings.TrimPrefix(req.URL.Path, hostsPath+"/"); id != req.URL.Path && !strings.Contains(id, "/") {
+		if host, ok := r.hosts[id]; ok {
+			return r.serveJSON(req, http.StatusOK, map[string]interface{}{"host": host})
+		}
+		return r.serveJSON(req, http.StatusNotFound, map[string]interface{}{"error": map[string]string{"message": "Host not found in the snapshot"}})
+	}
+
+	recorded, ok := r.responses[req.Method+" "+requestURL(req)]
+	if !ok {
+		return nil, errors.Errorf("%s %s is not recorded in the snapshot, take a new snapshot with the filters", req.Method, requestURL(req))
+	}
+
+	resp := newResponse(req, recorded.StatusCode, recorded.Body)
+	for name, v := range recorded.Header {
+		resp.Header.Set(name, v)
+	}
+
+	return resp, nil
+}
+
+// serveLatest serves the latest values of the hosts and metrics in the request from Snapshot.Latest
+func (r *replayer) serveLatest(req *http.Request) (*http.Response, error) {
+	latest := make(mackerel.LatestMetricValues)
+
+	q := req.URL.Query()
+	for _, id := range q["hostId"] {
+		latest[id] = make(map[string]*mackerel.MetricValue)
+
+		for _, name := range q["name"] {
+			v, ok := r.snapshot.Latest[id][name]
+			if !ok {
+				return nil, errors.Errorf("the latest value of %s of host %s is not recorded in the snapshot, take a new snapshot with the filters", name, id)
+			}
+			latest[id][name] = v
+		}
+	}
+
+	return r.serveJSON(req, http.StatusOK, map[string]interface{}{"tsdbLatest": latest})
+}
+
+func (r *replayer) serveJSON(req *http.Request, status int, v interface{}) (*http.Response, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return newResponse(req, status, b), nil
+}
+
+func newResponse(req *http.Request, status int, body []byte) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// requestURL returns the path and the query of the request with the parameters sorted by their names
+func requestURL(req *http.Request) string {
+	if q := req.URL.Query().Encode(); q != "" {
+		return req.URL.Path + "?" + q
+	}
+
+	return req.URL.Path
+}
@@ -0,0 +1,164 @@
+package mkk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shuheiktgw/mackerel-killer/pkg/mkktest"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+func TestMkk_TakeSnapshot(t *testing.T) {
+	m, mux, _, teardown := setup()
+
+	now := time.Date(2019, 5, 27, 0, 0, 0, 0, time.UTC)
+	m.Clock = FixedClock(now)
+	m.MetadataNamespace = DefaultMetadataNamespace
+
+	// a reports the metric, b does not and c is protected by its metadata
+	mux.HandleFunc("/api/v0/hosts/", func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/metadata/"+DefaultMetadataNamespace) {
+			if strings.Contains(r.URL.Path, "/c/") {
+				w.Header().Set("Last-Modified", now.Format(http.TimeFormat))
+				fmt.Fprint(w, `{"protect": true}`)
+				return
+			}
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error": {"message": "Metadata not found"}}`)
+			return
+		}
+
+		if got, want := r.URL.Query().Get("to"), fmt.Sprint(now.Unix()); got != want {
+			t.Errorf("invalid to: got: %v, want: %v", got, want)
+		}
+
+		if strings.Contains(r.URL.Path, "/a/") {
+			fmt.Fprint(w, `{"metrics": [{"time": 1558914000, "value": 1}]}`)
+		} else {
+			fmt.Fprint(w, `{"metrics": []}`)
+		}
+	})
+
+	hosts := []*mackerel.Host{
+		{ID: "a", Name: "a", Type: "agent"},
+		{ID: "b", Name: "b", Type: "unknown"},
+		{ID: "c", Name: "c", Type: "unknown"},
+	}
+
+	// The window is relative to the time the snapshot is taken
+	absence := &MetricAbsenceFilter{Name: "loadavg5", FromTime: Relative(-time.Hour)}
+
+	// HostFilter drops a but the metric of a is recorded as well
+	filters := []Filter{&HostFilter{Type: "unknown"}, absence}
+
+	s, err := m.TakeSnapshot(context.Background(), hosts, filters)
+	if err != nil {
+		t.Fatalf("Mkk.TakeSnapshot returned error: %v", err)
+	}
+
+	// The snapshot is evaluated without the server
+	teardown()
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		t.Fatalf("error occurred while marshaling the snapshot: %v", err)
+	}
+
+	var restored Snapshot
+	if err := json.Unmarshal(b, &restored); err != nil {
+		t.Fatalf("error occurred while unmarshaling the snapshot: %v", err)
+	}
+
+	replay := NewMkkFromSnapshot(&restored)
+	replay.MetadataNamespace = DefaultMetadataNamespace
+
+	found, err := replay.FindHosts(&mackerel.FindHostsParam{}, []Filter{filters[1]})
+	if err != nil {
+		t.Fatalf("Mkk.FindHosts returned error: %v", err)
+	}
+
+	var got []string
+	for _, h := range found {
+		got = append(got, h.ID)
+	}
+
+	if want := []string{"b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid hosts: got: %v, want: %v", got, want)
+	}
+
+	if err := replay.Check(found[1]); !IsRefused(err) {
+		t.Errorf("host protected by its metadata is supposed to be refused: got: %v", err)
+	}
+
+	if err := replay.Kill(found[0]); err == nil {
+		t.Errorf("Mkk.Kill is supposed to fail against a snapshot")
+	}
+
+	if _, err := replay.Filter(found, []Filter{&MetricAbsenceFilter{Name: "cpu.user.percentage"}}); err == nil {
+		t.Errorf("Mkk.Filter is supposed to fail for the metric not recorded in the snapshot")
+	}
+}
+
+func TestMkk_TakeSnapshot_LatestMetric(t *testing.T) {
+	server := mkktest.NewServer()
+	defer server.Close()
+
+	now := time.Date(2019, 5, 27, 0, 0, 0, 0, time.UTC)
+
+	// old is outside the grace period and new is inside it, and neither has reported the metric recently
+	server.AddHost(&mackerel.Host{ID: "old", Name: "old", Status: mackerel.HostStatusStandby, CreatedAt: int32(now.Unix() - 86400)})
+	server.AddHost(&mackerel.Host{ID: "new", Name: "new", Status: mackerel.HostStatusStandby, CreatedAt: int32(now.Unix() - 60)})
+	server.AddMetricValues("old", "loadavg5", mackerel.MetricValue{Time: now.Unix() - 3600, Value: 0.1})
+
+	m := NewMkk("")
+	m.Client.BaseURL = server.BaseURL()
+	m.Clock = FixedClock(now)
+
+	hosts, err := m.Client.FindHosts(&mackerel.FindHostsParam{})
+	if err != nil {
+		t.Fatalf("error occurred while finding hosts: %v", err)
+	}
+
+	// GracePeriodFilter drops new, so LatestMetricStaleFilter queries old alone while replaying
+	filters := []Filter{&GracePeriodFilter{Seconds: 3600}, &LatestMetricStaleFilter{Name: "loadavg5", Seconds: 600}}
+
+	s, err := m.TakeSnapshot(context.Background(), hosts, filters)
+	if err != nil {
+		t.Fatalf("Mkk.TakeSnapshot returned error: %v", err)
+	}
+
+	if got, want := len(s.Latest), 2; got != want {
+		t.Errorf("invalid number of hosts with the latest values: got: %v, want: %v", got, want)
+	}
+
+	replay := NewMkkFromSnapshot(s)
+
+	found, err := replay.FindHosts(&mackerel.FindHostsParam{}, filters)
+	if err != nil {
+		t.Fatalf("Mkk.FindHosts returned error: %v", err)
+	}
+
+	if got, want := hostIDs(found), []string{"old"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid hosts: got: %v, want: %v", got, want)
+	}
+
+	if _, err := replay.Filter(found, []Filter{&LatestMetricStaleFilter{Name: "cpu.user.percentage", Seconds: 600}}); err == nil {
+		t.Errorf("Mkk.Filter is supposed to fail for the latest metric not recorded in the snapshot")
+	}
+}
+
+func hostIDs(hosts []*mackerel.Host) []string {
+	ids := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		ids = append(ids, h.ID)
+	}
+
+	return ids
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"time"
 )
 
 // setup sets up a test HTTP server along with Mkk
@@ -22,5 +23,10 @@ func setup() (m *Mkk, mux *http.ServeMux, serverURL string, teardown func()) {
 	u, _ := url.Parse(server.URL + "/")
 	m.Client.BaseURL = u
 
+	// Keep retries fast in tests
+	m.Retry.BaseDelay = time.Millisecond
+	m.Retry.MaxDelay = 10 * time.Millisecond
+	m.RateLimiter = nil
+
 	return m, mux, server.URL, server.Close
 }
@@ -0,0 +1,227 @@
+package mkk
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Time is a point in time given to filters, which is either absolute or relative to now
+// In JSON, it is either epoch seconds, "now", a duration relative to now such as "-24h" or "-7d",
+// or an ISO-8601 timestamp such as "2019-05-27T00:00:00Z"
+// The zero value is the epoch
+type Time struct {
+	unix     int64
+	offset   time.Duration
+	relative bool
+}
+
+// Unix returns the absolute Time of the epoch seconds
+func Unix(sec int64) Time {
+	return Time{unix: sec}
+}
+
+// Relative returns the Time which is d after now, e.g. Relative(-24 * time.Hour) for 24 hours ago
+func Relative(d time.Duration) Time {
+	return Time{offset: d, relative: true}
+}
+
+// isoLayouts are the ISO-8601 layouts accepted by ParseTime, which are in UTC unless the offset is given
+var isoLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"}
+
+// ParseTime parses epoch seconds, "now", a duration relative to now or an ISO-8601 timestamp
+func ParseTime(s string) (Time, error) {
+	s = strings.TrimSpace(s)
+
+	if s == "now" {
+		return Relative(0), nil
+	}
+
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return Unix(sec), nil
+	}
+
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		d, err := ParseDuration(s)
+		if err != nil {
+			return Time{}, err
+		}
+		return Relative(d), nil
+	}
+
+	for _, layout := range isoLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return Unix(t.Unix()), nil
+		}
+	}
+
+	return Time{}, errors.Errorf("invalid time %q, must be epoch seconds, now, a relative duration like -24h or an ISO-8601 timestamp", s)
+}
+
+// Resolve returns the epoch seconds of the Time relative to now
+func (t Time) Resolve(now time.Time) int64 {
+	if t.relative {
+		return now.Add(t.offset).Unix()
+	}
+
+	return t.unix
+}
+
+// IsZero reports whether the Time is the zero value
+func (t Time) IsZero() bool {
+	return t == Time{}
+}
+
+func (t Time) String() string {
+	switch {
+	case !t.relative:
+		return strconv.FormatInt(t.unix, 10)
+	case t.offset == 0:
+		return "now"
+	case t.offset > 0:
+		return "+" + formatDuration(t.offset)
+	default:
+		return "-" + formatDuration(-t.offset)
+	}
+}
+
+// MarshalJSON encodes absolute Time in epoch seconds and relative Time in a string like -24h
+func (t Time) MarshalJSON() ([]byte, error) {
+	if !t.relative {
+		return []byte(strconv.FormatInt(t.unix, 10)), nil
+	}
+
+	return json.Marshal(t.String())
+}
+
+// UnmarshalJSON decodes either epoch seconds or a string accepted by ParseTime
+func (t *Time) UnmarshalJSON(b []byte) error {
+	var sec int64
+	if err := json.Unmarshal(b, &sec); err == nil {
+		*t = Unix(sec)
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errors.Errorf("invalid time %s, must be a number or a string", b)
+	}
+
+	parsed, err := ParseTime(s)
+	if err != nil {
+		return err
+	}
+
+	*t = parsed
+	return nil
+}
+
+// Duration is a length of time given to filters
+// In JSON, it is either seconds or a string such as "72h", "90m" or "3d"
+type Duration time.Duration
+
+// ParseDuration parses a duration string accepted by time.ParseDuration
+// along with days such as "3d" and "-7d"
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.ParseFloat(strings.TrimSuffix(s, "d"), 64)
+		if err != nil {
+			return 0, errors.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(days * float64(24*time.Hour)), nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, errors.Errorf("invalid duration %q", s)
+	}
+
+	return d, nil
+}
+
+func (d Duration) String() string {
+	return formatDuration(time.Duration(d))
+}
+
+// MarshalJSON encodes the Duration in a string like 72h
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON decodes either seconds or a string accepted by ParseDuration
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var sec int64
+	if err := json.Unmarshal(b, &sec); err == nil {
+		*d = Duration(time.Duration(sec) * time.Second)
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errors.Errorf("invalid duration %s, must be a number or a string", b)
+	}
+
+	parsed, err := ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+// splitTime returns the epoch seconds of absolute Time and relative Time as it is,
+// which are stored in the int64 field and the Time field of a filter respectively
+func splitTime(t Time) (int64, Time) {
+	if t.relative {
+		return 0, t
+	}
+
+	return t.unix, Time{}
+}
+
+// metricWindow returns the time window of metrics given in the epoch seconds and Time,
+// where Time takes precedence over the epoch seconds unless it is zero
+func metricWindow(from int64, fromTime Time, to int64, toTime Time) (Time, Time) {
+	if fromTime.IsZero() {
+		fromTime = Unix(from)
+	}
+	if toTime.IsZero() {
+		toTime = Unix(to)
+	}
+
+	return fromTime, toTime
+}
+
+// resolveWindow resolves the time window of metrics relative to now, where the zero to is now
+func resolveWindow(from, to Time, now time.Time) (int64, int64) {
+	if to.IsZero() {
+		to = Relative(0)
+	}
+
+	return from.Resolve(now), to.Resolve(now)
+}
+
+// formatDuration formats the duration without the trailing zero units, e.g. 72h instead of 72h0m0s
+func formatDuration(d time.Duration) string {
+	s := d.String()
+
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+
+	return s
+}
+
+// formatUnix formats the epoch seconds in RFC3339
+func formatUnix(sec int64) string {
+	return time.Unix(sec, 0).UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,111 @@
+package mkk
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2019, 5, 27, 12, 0, 0, 0, time.UTC)
+
+	var cases = []struct {
+		input string
+		want  int64
+	}{
+		{input: "now", want: now.Unix()},
+		{input: "-24h", want: now.Add(-24 * time.Hour).Unix()},
+		{input: "-7d", want: now.Add(-7 * 24 * time.Hour).Unix()},
+		{input: "+90m", want: now.Add(90 * time.Minute).Unix()},
+		{input: "1558910000", want: 1558910000},
+		{input: "2019-05-27T09:00:00+09:00", want: now.Add(-12 * time.Hour).Unix()},
+		{input: "2019-05-27", want: now.Add(-12 * time.Hour).Unix()},
+	}
+
+	for i, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			parsed, err := ParseTime(tc.input)
+			if err != nil {
+				t.Fatalf("#%d ParseTime returned error: %v", i, err)
+			}
+
+			if got, want := parsed.Resolve(now), tc.want; got != want {
+				t.Errorf("#%d invalid time: got: %v, want: %v", i, got, want)
+			}
+		})
+	}
+
+	for _, input := range []string{"yesterday", "-24x", "2019/05/27"} {
+		if _, err := ParseTime(input); err == nil {
+			t.Errorf("ParseTime is supposed to return error for %q", input)
+		}
+	}
+}
+
+func TestTime_JSON(t *testing.T) {
+	var f MetricAbsenceFilter
+	if err := json.Unmarshal([]byte(`{"name":"loadavg5","from":"-24h","to":"now"}`), &f); err != nil {
+		t.Fatalf("error occurred while unmarshaling the filter: %v", err)
+	}
+
+	if got, want := f.FromTime, Relative(-24*time.Hour); got != want {
+		t.Errorf("invalid from: got: %v, want: %v", got, want)
+	}
+
+	// Resolved relative to now, where to is now
+	now := time.Unix(1558915200, 0)
+	fromTime, toTime := f.window()
+	from, to := resolveWindow(fromTime, toTime, now)
+	if from != 1558915200-24*3600 || to != 1558915200 {
+		t.Errorf("invalid window: got: %v-%v", from, to)
+	}
+
+	// FromTime and ToTime take precedence over From and To
+	rel := MetricAbsenceFilter{Name: "loadavg5", From: 1, To: 100, FromTime: Relative(-time.Hour)}
+	fromTime, toTime = rel.window()
+	if from, to := resolveWindow(fromTime, toTime, now); from != 1558915200-3600 || to != 100 {
+		t.Errorf("invalid window: got: %v-%v", from, to)
+	}
+
+	// Absolute times are kept in epoch seconds
+	var abs MetricAbsenceFilter
+	if err := json.Unmarshal([]byte(`{"name":"loadavg5","from":"2019-05-27T00:00:00Z","to":1558915300}`), &abs); err != nil {
+		t.Fatalf("error occurred while unmarshaling the filter: %v", err)
+	}
+
+	if got, want := abs, (MetricAbsenceFilter{Name: "loadavg5", From: 1558915200, To: 1558915300}); got != want {
+		t.Errorf("invalid filter: got: %+v, want: %+v", got, want)
+	}
+
+	if got, want := DescribeFilter(&f), "MetricAbsenceFilter{Name:loadavg5 From:-24h To:now Concurrency:0 RequestsPerSecond:0}"; got != want {
+		t.Errorf("invalid description: got: %v, want: %v", got, want)
+	}
+
+	b, err := json.Marshal(struct{ From, To Time }{f.FromTime, Unix(1558910000)})
+	if err != nil {
+		t.Fatalf("error occurred while marshaling times: %v", err)
+	}
+
+	if got, want := string(b), `{"From":"-24h","To":1558910000}`; got != want {
+		t.Errorf("invalid JSON: got: %v, want: %v", got, want)
+	}
+}
+
+func TestGracePeriodFilter_Period(t *testing.T) {
+	f, err := NewFilter("GracePeriodFilter", []byte(`{"period":"72h"}`))
+	if err != nil {
+		t.Fatalf("NewFilter returned error: %v", err)
+	}
+
+	if got, want := f.(*GracePeriodFilter).Period, Duration(72*time.Hour); got != want {
+		t.Errorf("invalid period: got: %v, want: %v", got, want)
+	}
+
+	if got, want := DescribeFilter(f), "GracePeriodFilter{Period:72h}"; got != want {
+		t.Errorf("invalid description: got: %v, want: %v", got, want)
+	}
+
+	if _, err := NewFilter("GracePeriodFilter", []byte(`{"seconds":100,"period":"72h"}`)); err == nil {
+		t.Errorf("NewFilter is supposed to return error when both seconds and period are given")
+	}
+}
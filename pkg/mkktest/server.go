@@ -0,0 +1,480 @@
+// Package mkktest provides a fake Mackerel API for testing mkk and its configs without a Mackerel organization
+package mkktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+// Server is an httptest based fake Mackerel API which keeps hosts, metric values,
+// host metadata and retire state in memory
+// It implements the endpoints mkk uses along with the ones to create hosts, post metrics and put metadata
+type Server struct {
+	*httptest.Server
+
+	// Now tells the time used for createdAt of hosts and Last-Modified of metadata, which is time.Now by default
+	Now func() time.Time
+
+	mu       sync.Mutex
+	nextID   int
+	ids      []string
+	hosts    map[string]*mackerel.Host
+	metrics  map[string]map[string][]mackerel.MetricValue
+	metadata map[string]map[string]interface{}
+	requests []string
+}
+
+// NewServer starts a fake Mackerel API, which has to be closed by Close
+func NewServer() *Server {
+	s := Server{
+		Now:      time.Now,
+		hosts:    make(map[string]*mackerel.Host),
+		metrics:  make(map[string]map[string][]mackerel.MetricValue),
+		metadata: make(map[string]map[string]interface{}),
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+
+	return &s
+}
+
+// BaseURL returns the URL to set to mackerel.Client.BaseURL
+func (s *Server) BaseURL() *url.URL {
+	u, _ := url.Parse(s.URL + "/")
+	return u
+}
+
+// AddHost adds a copy of the host and returns its ID
+// ID, Type, Status and CreatedAt are set when they are empty
+func (s *Server) AddHost(host *mackerel.Host) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addHost(host)
+}
+
+func (s *Server) addHost(host *mackerel.Host) string {
+	h := *host
+
+	if h.ID == "" {
+		s.nextID++
+		h.ID = fmt.Sprintf("host%d", s.nextID)
+	}
+	if h.Type == "" {
+		h.Type = "unknown"
+	}
+	if h.Status == "" {
+		h.Status = mackerel.HostStatusWorking
+	}
+	if h.CreatedAt == 0 {
+		h.CreatedAt = int32(s.Now().Unix())
+	}
+
+	if _, ok := s.hosts[h.ID]; !ok {
+		s.ids = append(s.ids, h.ID)
+	}
+	s.hosts[h.ID] = &h
+
+	return h.ID
+}
+
+// Host returns a copy of the host with the ID, which is nil when the host does not exist
+func (s *Server) Host(id string) *mackerel.Host {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, ok := s.hosts[id]
+	if !ok {
+		return nil
+	}
+
+	c := *h
+	return &c
+}
+
+// RetiredHosts returns the IDs of the retired hosts in the order they were added
+func (s *Server) RetiredHosts() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ids []string
+	for _, id := range s.ids {
+		if s.hosts[id].IsRetired {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+// AddMetricValues adds the values of the named metric to the host
+func (s *Server) AddMetricValues(hostID, name string, values ...mackerel.MetricValue) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.addMetricValues(hostID, name, values...)
+}
+
+func (s *Server) addMetricValues(hostID, name string, values ...mackerel.MetricValue) {
+	if s.metrics[hostID] == nil {
+		s.metrics[hostID] = make(map[string][]mackerel.MetricValue)
+	}
+
+	series := append(s.metrics[hostID][name], values...)
+	sort.SliceStable(series, func(i, j int) bool { return series[i].Time < series[j].Time })
+	s.metrics[hostID][name] = series
+}
+
+// SetMetadata sets the host metadata in the namespace
+func (s *Server) SetMetadata(hostID, namespace string, metadata interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.setMetadata(hostID, namespace, metadata)
+}
+
+func (s *Server) setMetadata(hostID, namespace string, metadata interface{}) {
+	if s.metadata[hostID] == nil {
+		s.metadata[hostID] = make(map[string]interface{})
+	}
+
+	s.metadata[hostID][namespace] = metadata
+}
+
+// Requests returns the requests received so far such as "GET /api/v0/hosts"
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.requests...)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(segments) < 3 || segments[0] != "api" || segments[1] != "v0" {
+		writeError(w, http.StatusNotFound, "API not found")
+		return
+	}
+
+	switch route := segments[2:]; {
+	case r.Method == http.MethodGet && match(route, "hosts"):
+		s.findHosts(w, r)
+	case r.Method == http.MethodPost && match(route, "hosts"):
+		s.createHost(w, r)
+	case r.Method == http.MethodPost && match(route, "hosts", "bulk-retire"):
+		s.bulkRetire(w, r)
+	case r.Method == http.MethodGet && match(route, "hosts", "*"):
+		s.findHost(w, route[1])
+	case r.Method == http.MethodPost && match(route, "hosts", "*", "retire"):
+		s.retire(w, route[1])
+	case r.Method == http.MethodGet && match(route, "hosts", "*", "metrics"):
+		s.fetchMetricValues(w, r, route[1])
+	case match(route, "hosts", "*", "metadata", "*"):
+		s.serveMetadata(w, r, route[1], route[3])
+	case r.Method == http.MethodPost && match(route, "tsdb"):
+		s.postMetricValues(w, r)
+	case r.Method == http.MethodGet && match(route, "tsdb", "latest"):
+		s.fetchLatestMetricValues(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "API not found")
+	}
+}
+
+// match reports whether the path segments match the pattern, where * matches any segment
+func match(segments []string, pattern ...string) bool {
+	if len(segments) != len(pattern) {
+		return false
+	}
+
+	for i, p := range pattern {
+		if p != "*" && p != segments[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// findHosts finds the hosts which are not retired in the same way as Mackerel
+// Hosts are filtered by service, role, name, status and customIdentifier,
+// and the statuses are working and standby by default
+func (s *Server) findHosts(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	statuses := q["status"]
+	if len(statuses) == 0 {
+		statuses = []string{mackerel.HostStatusWorking, mackerel.HostStatusStandby}
+	}
+
+	hosts := []*mackerel.Host{}
+	for _, id := range s.ids {
+		h := s.hosts[id]
+
+		if h.IsRetired || !contains(statuses, h.Status) {
+			continue
+		}
+
+		if name := q.Get("name"); name != "" && h.Name != name {
+			continue
+		}
+
+		if ci := q.Get("customIdentifier"); ci != "" && h.CustomIdentifier != ci {
+			continue
+		}
+
+		if service := q.Get("service"); service != "" {
+			roles, ok := h.Roles[service]
+			if !ok {
+				continue
+			}
+
+			if rs := q["role"]; len(rs) > 0 && !containsAny(roles, rs) {
+				continue
+			}
+		}
+
+		hosts = append(hosts, h)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"hosts": hosts})
+}
+
+func (s *Server) createHost(w http.ResponseWriter, r *http.Request) {
+	var param mackerel.CreateHostParam
+	if err := json.NewDecoder(r.Body).Decode(&param); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	roles := mackerel.Roles{}
+	for _, fullname := range param.RoleFullnames {
+		sr := strings.SplitN(fullname, ":", 2)
+		if len(sr) != 2 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid role fullname: %s", fullname))
+			return
+		}
+		roles[sr[0]] = append(roles[sr[0]], sr[1])
+	}
+
+	id := s.addHost(&mackerel.Host{
+		Name:             param.Name,
+		DisplayName:      param.DisplayName,
+		CustomIdentifier: param.CustomIdentifier,
+		Roles:            roles,
+		Meta:             param.Meta,
+		Interfaces:       param.Interfaces,
+	})
+
+	writeJSON(w, http.StatusOK, map[string]string{"id": id})
+}
+
+// findHost returns the host even if it is retired as Mackerel does
+func (s *Server) findHost(w http.ResponseWriter, id string) {
+	h, ok := s.hosts[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Host not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"host": h})
+}
+
+func (s *Server) retire(w http.ResponseWriter, id string) {
+	h, ok := s.hosts[id]
+	if !ok || h.IsRetired {
+		writeError(w, http.StatusNotFound, "Host not found")
+		return
+	}
+
+	h.IsRetired = true
+
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// bulkRetire retires all the hosts or none of them when any of them cannot be retired
+func (s *Server) bulkRetire(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		IDs []string `json:"ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	for _, id := range body.IDs {
+		if h, ok := s.hosts[id]; !ok || h.IsRetired {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("Host not found: %s", id))
+			return
+		}
+	}
+
+	for _, id := range body.IDs {
+		s.hosts[id].IsRetired = true
+	}
+
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// fetchMetricValues returns the values of the metric between from and to inclusive
+// It fails when the host has never reported the metric as Mackerel does
+func (s *Server) fetchMetricValues(w http.ResponseWriter, r *http.Request, id string) {
+	if _, ok := s.hosts[id]; !ok {
+		writeError(w, http.StatusNotFound, "Host not found")
+		return
+	}
+
+	q := r.URL.Query()
+
+	from, err := strconv.ParseInt(q.Get("from"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid from")
+		return
+	}
+
+	to, err := strconv.ParseInt(q.Get("to"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid to")
+		return
+	}
+
+	series, ok := s.metrics[id][q.Get("name")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Metric not found")
+		return
+	}
+
+	values := []mackerel.MetricValue{}
+	for _, v := range series {
+		if from <= v.Time && v.Time <= to {
+			values = append(values, mackerel.MetricValue{Time: v.Time, Value: v.Value})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"metrics": values})
+}
+
+func (s *Server) postMetricValues(w http.ResponseWriter, r *http.Request) {
+	var values []*mackerel.HostMetricValue
+	if err := json.NewDecoder(r.Body).Decode(&values); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	for _, v := range values {
+		if v.MetricValue == nil {
+			writeError(w, http.StatusBadRequest, "missing metric value")
+			return
+		}
+
+		if _, ok := s.hosts[v.HostID]; !ok {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("Host not found: %s", v.HostID))
+			return
+		}
+	}
+
+	for _, v := range values {
+		s.addMetricValues(v.HostID, v.Name, mackerel.MetricValue{Time: v.Time, Value: v.Value})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// fetchLatestMetricValues returns the latest values of the metrics, omitting the ones never reported
+func (s *Server) fetchLatestMetricValues(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	latest := mackerel.LatestMetricValues{}
+	for _, id := range q["hostId"] {
+		latest[id] = map[string]*mackerel.MetricValue{}
+
+		for _, name := range q["name"] {
+			series := s.metrics[id][name]
+			if len(series) == 0 {
+				continue
+			}
+
+			v := series[len(series)-1]
+			latest[id][name] = &mackerel.MetricValue{Time: v.Time, Value: v.Value}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"tsdbLatest": latest})
+}
+
+func (s *Server) serveMetadata(w http.ResponseWriter, r *http.Request, id, namespace string) {
+	if _, ok := s.hosts[id]; !ok {
+		writeError(w, http.StatusNotFound, "Host not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		metadata, ok := s.metadata[id][namespace]
+		if !ok {
+			writeError(w, http.StatusNotFound, "Metadata not found")
+			return
+		}
+
+		w.Header().Set("Last-Modified", s.Now().UTC().Format(http.TimeFormat))
+		writeJSON(w, http.StatusOK, metadata)
+	case http.MethodPut:
+		var metadata interface{}
+		if err := json.NewDecoder(r.Body).Decode(&metadata); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		s.setMetadata(id, namespace, metadata)
+		writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+	case http.MethodDelete:
+		delete(s.metadata[id], namespace)
+		writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]map[string]string{"error": {"message": message}})
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsAny(ss, candidates []string) bool {
+	for _, c := range candidates {
+		if contains(ss, c) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,140 @@
+package mkktest
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mackerelio/mackerel-client-go"
+)
+
+func newClient(s *Server) *mackerel.Client {
+	client := mackerel.NewClient("")
+	client.BaseURL = s.BaseURL()
+	return client
+}
+
+func bulkRetireHosts(client *mackerel.Client, ids []string) error {
+	resp, err := client.PostJSON("/api/v0/hosts/bulk-retire", map[string][]string{"ids": ids})
+	if resp != nil {
+		resp.Body.Close()
+	}
+
+	return err
+}
+
+func TestServer_FindHosts(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	s.AddHost(&mackerel.Host{ID: "web", Name: "web", Roles: mackerel.Roles{"service": {"web"}}})
+	s.AddHost(&mackerel.Host{ID: "db", Name: "db", Roles: mackerel.Roles{"service": {"db"}}})
+	s.AddHost(&mackerel.Host{ID: "poweroff", Name: "poweroff", Status: mackerel.HostStatusPoweroff})
+	s.AddHost(&mackerel.Host{ID: "retired", Name: "retired", IsRetired: true})
+
+	cases := []struct {
+		param mackerel.FindHostsParam
+		want  []string
+	}{
+		{param: mackerel.FindHostsParam{}, want: []string{"web", "db"}},
+		{param: mackerel.FindHostsParam{Service: "service"}, want: []string{"web", "db"}},
+		{param: mackerel.FindHostsParam{Service: "service", Roles: []string{"db"}}, want: []string{"db"}},
+		{param: mackerel.FindHostsParam{Name: "web"}, want: []string{"web"}},
+		{param: mackerel.FindHostsParam{Statuses: []string{mackerel.HostStatusPoweroff}}, want: []string{"poweroff"}},
+	}
+
+	client := newClient(s)
+	for i, tc := range cases {
+		hosts, err := client.FindHosts(&tc.param)
+		if err != nil {
+			t.Fatalf("#%d error occurred while finding hosts: %v", i, err)
+		}
+
+		var got []string
+		for _, h := range hosts {
+			got = append(got, h.ID)
+		}
+
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("#%d invalid hosts: got: %v, want: %v", i, got, tc.want)
+		}
+	}
+}
+
+func TestServer_BulkRetire(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	s.AddHost(&mackerel.Host{ID: "a"})
+	s.AddHost(&mackerel.Host{ID: "b"})
+
+	client := newClient(s)
+	if err := bulkRetireHosts(client, []string{"a", "unknown"}); err == nil {
+		t.Fatalf("error is expected while retiring an unknown host")
+	}
+
+	if got := s.RetiredHosts(); len(got) != 0 {
+		t.Fatalf("no hosts should be retired on failure: got: %v", got)
+	}
+
+	if err := bulkRetireHosts(client, []string{"a", "b"}); err != nil {
+		t.Fatalf("error occurred while retiring hosts: %v", err)
+	}
+
+	if got, want := s.RetiredHosts(), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid retired hosts: got: %v, want: %v", got, want)
+	}
+}
+
+func TestServer_Metrics(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	s.AddHost(&mackerel.Host{ID: "a"})
+	s.AddMetricValues("a", "loadavg5", mackerel.MetricValue{Time: 200, Value: 2.0}, mackerel.MetricValue{Time: 100, Value: 1.0})
+
+	client := newClient(s)
+	values, err := client.FetchHostMetricValues("a", "loadavg5", 100, 150)
+	if err != nil {
+		t.Fatalf("error occurred while fetching metric values: %v", err)
+	}
+
+	if got, want := len(values), 1; got != want {
+		t.Fatalf("invalid number of metric values: got: %v, want: %v", got, want)
+	}
+
+	if _, err := client.FetchHostMetricValues("a", "unknown", 0, 300); err == nil {
+		t.Errorf("error is expected while fetching an unknown metric")
+	}
+
+	latest, err := client.FetchLatestMetricValues([]string{"a"}, []string{"loadavg5"})
+	if err != nil {
+		t.Fatalf("error occurred while fetching latest metric values: %v", err)
+	}
+
+	if got, want := latest["a"]["loadavg5"].Value, 2.0; got != want {
+		t.Errorf("invalid latest metric value: got: %v, want: %v", got, want)
+	}
+}
+
+func TestServer_Metadata(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	s.AddHost(&mackerel.Host{ID: "a"})
+
+	client := newClient(s)
+	if _, err := client.GetHostMetaData("a", "mackerel-killer"); err == nil {
+		t.Fatalf("error is expected while getting missing metadata")
+	}
+
+	s.SetMetadata("a", "mackerel-killer", map[string]interface{}{"protect": true})
+
+	resp, err := client.GetHostMetaData("a", "mackerel-killer")
+	if err != nil {
+		t.Fatalf("error occurred while getting metadata: %v", err)
+	}
+
+	if got, want := resp.HostMetaData, map[string]interface{}{"protect": true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid metadata: got: %v, want: %v", got, want)
+	}
+}